@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// authGateHeaderLimit - Taille maximale des en-têtes d'un CONNECT lus par la passerelle
+const authGateHeaderLimit = 64 * 1024
+
+// relayedClients - Adresse réelle des clients relayés, par adresse locale de la connexion relais
+//
+// go-mitmproxy ne voit que la connexion de la passerelle: clientAddress
+// retrouve l'adresse du client d'origine.
+var relayedClients sync.Map
+
+// clientAddress - Adresse du client d'une connexion acceptée par go-mitmproxy
+func clientAddress(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if real, ok := relayedClients.Load(addr); ok {
+		return real.(string)
+	}
+	return addr
+}
+
+// authGate - Passerelle d'authentification placée devant go-mitmproxy
+//
+// go-mitmproxy ne permet pas de répondre à un CONNECT: la passerelle lit la
+// première requête de chaque connexion et répond elle-même 407, avec le défi
+// Proxy-Authenticate, à un CONNECT sans identifiants valides. Le client peut
+// réessayer sur la même connexion. Toute autre requête, et un CONNECT
+// authentifié, est relayée sans modification vers go-mitmproxy, qui écoute
+// sur une adresse locale et vérifie à nouveau l'en-tête (voir admit).
+type authGate struct {
+	auth     *proxyAuthenticator
+	listener net.Listener
+	backend  string // adresse d'écoute de go-mitmproxy
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// newAuthGate - Écouter sur addr et relayer vers go-mitmproxy à l'adresse backend
+func newAuthGate(addr, backend string, auth *proxyAuthenticator) (*authGate, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	g := &authGate{
+		auth:     auth,
+		listener: listener,
+		backend:  backend,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	g.wg.Add(1)
+	go g.serve()
+	return g, nil
+}
+
+// freeLocalAddr - Trouver une adresse locale libre (go-mitmproxy, relais)
+func freeLocalAddr() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return listener.Addr().String(), nil
+}
+
+// Addr - Adresse d'écoute de la passerelle
+func (g *authGate) Addr() net.Addr {
+	return g.listener.Addr()
+}
+
+// Close - Cesser d'accepter des connexions et fermer celles en cours
+func (g *authGate) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	for conn := range g.conns {
+		conn.Close()
+	}
+	g.mu.Unlock()
+
+	err := g.listener.Close()
+	g.wg.Wait()
+	return err
+}
+
+// serve - Accepter les connexions des clients
+func (g *authGate) serve() {
+	defer g.wg.Done()
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Passerelle d'authentification: %v", err)
+			}
+			return
+		}
+		if !g.track(conn) {
+			conn.Close()
+			return
+		}
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			defer g.untrack(conn)
+			g.handle(conn)
+		}()
+	}
+}
+
+// track - Retenir une connexion pour la fermer à l'arrêt
+func (g *authGate) track(conn net.Conn) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return false
+	}
+	g.conns[conn] = struct{}{}
+	return true
+}
+
+// untrack - Fermer et oublier une connexion
+func (g *authGate) untrack(conn net.Conn) {
+	conn.Close()
+	g.mu.Lock()
+	delete(g.conns, conn)
+	g.mu.Unlock()
+}
+
+// handle - Refuser les CONNECT non authentifiés, puis relayer la connexion
+func (g *authGate) handle(conn net.Conn) {
+	reader := bufio.NewReaderSize(conn, authGateHeaderLimit)
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		host = conn.RemoteAddr().String()
+	}
+
+	for {
+		head, err := peekHeader(reader)
+		if err != nil {
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+		if err != nil || req.Method != http.MethodConnect {
+			// Requête illisible ou relayée: go-mitmproxy répond lui-même
+			break
+		}
+		header := req.Header.Get(proxyAuthorizationHeader)
+		if header != "" {
+			if _, ok := g.auth.authenticate(header, host); ok {
+				break
+			}
+		}
+
+		g.auth.failures.Add(1)
+		log.Printf("Authentification au proxy refusée: CONNECT %s", req.Host)
+		reader.Discard(len(head))
+		if err := g.auth.challenge(req.ProtoAtLeast(1, 1)).Write(conn); err != nil {
+			return
+		}
+		if req.Close || req.ContentLength != 0 || len(req.TransferEncoding) > 0 {
+			return
+		}
+	}
+
+	backend, err := g.dialBackend(conn.RemoteAddr().String())
+	if err != nil {
+		log.Printf("Passerelle d'authentification: connexion au proxy impossible: %v", err)
+		return
+	}
+	defer backend.Close()
+	defer relayedClients.Delete(backend.LocalAddr().String())
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(backend, reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, backend)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// dialBackend - Se connecter à go-mitmproxy pour le compte d'un client
+//
+// L'adresse locale est choisie et associée au client avant la connexion:
+// go-mitmproxy signale la connexion (ClientConnected) dès son acceptation.
+func (g *authGate) dialBackend(client string) (net.Conn, error) {
+	var lastErr error
+	for attempt := 0; attempt < 3; attempt++ {
+		local, err := freeLocalAddr()
+		if err != nil {
+			return nil, err
+		}
+		localAddr, err := net.ResolveTCPAddr("tcp", local)
+		if err != nil {
+			return nil, err
+		}
+		relayedClients.Store(local, client)
+		conn, err := (&net.Dialer{LocalAddr: localAddr}).Dial("tcp", g.backend)
+		if err == nil {
+			return conn, nil
+		}
+		relayedClients.Delete(local)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// peekHeader - Lire sans les consommer la ligne de requête et les en-têtes
+func peekHeader(reader *bufio.Reader) ([]byte, error) {
+	for {
+		buf, _ := reader.Peek(reader.Buffered())
+		if end := bytes.Index(buf, []byte("\r\n\r\n")); end >= 0 {
+			return buf[:end+4], nil
+		}
+		if len(buf) >= authGateHeaderLimit {
+			return nil, errors.New("en-têtes trop volumineux")
+		}
+		if _, err := reader.Peek(len(buf) + 1); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// challenge - Réponse 407 d'un CONNECT refusé
+func (a *proxyAuthenticator) challenge(keepAlive bool) *http.Response {
+	return &http.Response{
+		StatusCode:    http.StatusProxyAuthRequired,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        a.challengeHeader(),
+		Body:          io.NopCloser(strings.NewReader(proxyAuthRequiredBody)),
+		ContentLength: int64(len(proxyAuthRequiredBody)),
+		Close:         !keepAlive,
+	}
+}
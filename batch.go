@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Formats de lot acceptés par le point de terminaison bulk
+const (
+	BatchFormatJSON   = "json"   // tableau JSON
+	BatchFormatNDJSON = "ndjson" // un objet JSON par ligne
+)
+
+// batchMaxInFlight - Nombre maximal de lots en cours d'envoi simultanément
+const batchMaxInFlight = 4
+
+// errBatchRejected - Lot refusé en bloc (4xx) sans détail des éléments fautifs
+var errBatchRejected = errors.New("lot rejeté par le logger")
+
+// bulkResult - Réponse optionnelle du point de terminaison bulk en cas d'échec partiel
+//
+// Le logger peut répondre 2xx (ou 207) avec la liste des éléments refusés,
+// identifiés par leur index dans le lot. Les éléments sans code d'état ou
+// avec un code >= 500 sont réessayés, les autres sont abandonnés. La même
+// liste est exploitée si le lot entier est refusé avec un code 4xx.
+type bulkResult struct {
+	Errors []struct {
+		Index   int    `json:"index"`
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// batchDispatcher - Regroupe les entrées de journal et les envoie par lots
+type batchDispatcher struct {
+	endpoint   string
+	format     string
+	maxCount   int
+	maxBytes   int
+	maxLatency time.Duration
+	maxRetries int
+	retryDelay time.Duration
+	httpClient *http.Client
+
+	// onFailure reçoit les éléments définitivement non livrés
+	onFailure func([]SpoolRecord)
+
+	in       chan SpoolRecord
+	done     chan struct{}
+	inFlight chan struct{}
+	sending  sync.WaitGroup
+
+	// busy compte, par flux, les lots en vol qui le contiennent
+	mu   sync.Mutex
+	idle *sync.Cond
+	busy map[string]int
+}
+
+// newBatchDispatcher - Créer un dispatcher de lots à partir de la configuration
+func newBatchDispatcher(config Config, httpClient *http.Client, onFailure func([]SpoolRecord)) *batchDispatcher {
+	b := &batchDispatcher{
+		endpoint:   config.BatchEndpoint,
+		format:     config.BatchFormat,
+		maxCount:   config.BatchMaxCount,
+		maxBytes:   config.BatchMaxBytes,
+		maxLatency: config.BatchMaxLatency,
+		maxRetries: config.MaxRetries,
+		retryDelay: config.RetryDelay,
+		httpClient: httpClient,
+		onFailure:  onFailure,
+		in:         make(chan SpoolRecord, config.BatchMaxCount),
+		done:       make(chan struct{}),
+		inFlight:   make(chan struct{}, batchMaxInFlight),
+		busy:       make(map[string]int),
+	}
+	b.idle = sync.NewCond(&b.mu)
+	go b.run()
+	return b
+}
+
+// Add - Ajouter une entrée au lot courant
+func (b *batchDispatcher) Add(rec SpoolRecord) {
+	b.in <- rec
+}
+
+// Close - Envoyer le lot en cours, attendre les envois en vol puis arrêter le dispatcher
+func (b *batchDispatcher) Close() {
+	close(b.in)
+	<-b.done
+	b.sending.Wait()
+}
+
+// run - Accumuler les entrées et déclencher l'envoi au premier seuil atteint
+func (b *batchDispatcher) run() {
+	defer close(b.done)
+
+	var batch []SpoolRecord
+	size := 0
+	timer := time.NewTimer(b.maxLatency)
+	stopTimer(timer)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		stopTimer(timer)
+		b.dispatch(batch)
+		batch = nil
+		size = 0
+	}
+
+	for {
+		select {
+		case rec, ok := <-b.in:
+			if !ok {
+				flush()
+				return
+			}
+
+			// Le lot courant déborderait: l'envoyer avant d'ajouter l'entrée
+			if len(batch) > 0 && size+len(rec.Entry) > b.maxBytes {
+				flush()
+			}
+			if len(batch) == 0 {
+				timer.Reset(b.maxLatency)
+			}
+			batch = append(batch, rec)
+			size += len(rec.Entry)
+
+			if len(batch) >= b.maxCount || size >= b.maxBytes {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// stopTimer - Arrêter le minuteur et vider un éventuel tick en attente
+//
+// Sans cela un tick périmé déclencherait l'envoi prématuré du lot suivant.
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
+
+// dispatch - Envoyer un lot en arrière-plan pour ne pas bloquer l'accumulation
+//
+// Le nombre d'envois simultanés est borné: au-delà, l'accumulation attend.
+// Un lot qui contient un flux encore présent dans un lot en vol attend la
+// fin de celui-ci, tentatives comprises: la mise à jour d'un flux n'est
+// jamais envoyée avant sa création.
+func (b *batchDispatcher) dispatch(batch []SpoolRecord) {
+	keys := batchKeys(batch)
+	b.acquire(keys)
+	b.inFlight <- struct{}{}
+	b.sending.Add(1)
+	go func() {
+		defer func() {
+			<-b.inFlight
+			b.release(keys)
+			b.sending.Done()
+		}()
+		b.send(batch)
+	}()
+}
+
+// acquire - Attendre qu'aucun lot en vol ne contienne ces clés puis les réserver
+func (b *batchDispatcher) acquire(keys []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.conflicts(keys) {
+		b.idle.Wait()
+	}
+	for _, key := range keys {
+		b.busy[key]++
+	}
+}
+
+// conflicts - Indiquer si l'une des clés est réservée par un lot en vol
+func (b *batchDispatcher) conflicts(keys []string) bool {
+	for _, key := range keys {
+		if b.busy[key] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// release - Libérer les clés d'un lot envoyé
+func (b *batchDispatcher) release(keys []string) {
+	b.mu.Lock()
+	for _, key := range keys {
+		if b.busy[key]--; b.busy[key] <= 0 {
+			delete(b.busy, key)
+		}
+	}
+	b.mu.Unlock()
+	b.idle.Broadcast()
+}
+
+// batchKeys - Flux distincts d'un lot
+func batchKeys(batch []SpoolRecord) []string {
+	seen := make(map[string]bool, len(batch))
+	var keys []string
+	for _, rec := range batch {
+		var entry struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(rec.Entry, &entry) != nil || entry.ID == "" || seen[entry.ID] {
+			continue
+		}
+		seen[entry.ID] = true
+		keys = append(keys, entry.ID)
+	}
+	return keys
+}
+
+// send - Envoyer un lot avec des tentatives, en ne réessayant que les éléments en échec
+func (b *batchDispatcher) send(batch []SpoolRecord) {
+	pending := batch
+	for i := 0; i <= b.maxRetries && len(pending) > 0; i++ {
+		if i > 0 {
+			// Backoff exponentiel
+			time.Sleep(b.retryDelay * time.Duration(1<<uint(i-1)))
+		}
+
+		retry, err := b.post(pending)
+		if errors.Is(err, errBatchRejected) && len(pending) > 1 {
+			// Isoler les éléments fautifs en les renvoyant un par un
+			for _, rec := range pending {
+				b.send([]SpoolRecord{rec})
+			}
+			return
+		}
+		if errors.Is(err, errBatchRejected) {
+			log.Printf("Journal rejeté par le logger: %v", err)
+			return
+		}
+		if err != nil {
+			log.Printf("Erreur lors de l'envoi d'un lot de %d journaux: %v", len(pending), err)
+			continue
+		}
+		pending = retry
+	}
+
+	if len(pending) > 0 {
+		log.Printf("Échec de l'envoi de %d journaux par lot après %d tentatives", len(pending), b.maxRetries)
+		if b.onFailure != nil {
+			b.onFailure(pending)
+		}
+	}
+}
+
+// post - Effectuer une tentative d'envoi et retourner les éléments à réessayer
+func (b *batchDispatcher) post(batch []SpoolRecord) ([]SpoolRecord, error) {
+	body, contentType, err := b.encode(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", b.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("code d'état %d", resp.StatusCode)
+	}
+
+	// Traiter un éventuel échec partiel
+	var result bulkResult
+	data, _ := io.ReadAll(resp.Body)
+	detailed := len(bytes.TrimSpace(data)) > 0 && json.Unmarshal(data, &result) == nil && len(result.Errors) > 0
+
+	if resp.StatusCode >= 400 {
+		if !detailed {
+			return nil, fmt.Errorf("%w avec le code d'état %d", errBatchRejected, resp.StatusCode)
+		}
+		// Lot refusé en bloc: seuls les éléments refusés en 4xx sont abandonnés, les autres sont renvoyés
+		rejected := make(map[int]bool)
+		for _, e := range result.Errors {
+			if e.Index >= 0 && e.Index < len(batch) && e.Status >= 400 && e.Status < 500 {
+				rejected[e.Index] = true
+				log.Printf("Journal rejeté par le logger dans un lot (code %d): %s", e.Status, e.Message)
+			}
+		}
+		var retry []SpoolRecord
+		for i, rec := range batch {
+			if !rejected[i] {
+				retry = append(retry, rec)
+			}
+		}
+		return retry, nil
+	}
+	if !detailed {
+		return nil, nil
+	}
+
+	var retry []SpoolRecord
+	for _, e := range result.Errors {
+		if e.Index < 0 || e.Index >= len(batch) {
+			continue
+		}
+		if e.Status == 0 || e.Status >= 500 {
+			retry = append(retry, batch[e.Index])
+			continue
+		}
+		log.Printf("Journal rejeté par le logger dans un lot (code %d): %s", e.Status, e.Message)
+	}
+	return retry, nil
+}
+
+// encode - Sérialiser un lot selon le format configuré
+func (b *batchDispatcher) encode(batch []SpoolRecord) ([]byte, string, error) {
+	if b.format == BatchFormatNDJSON {
+		var buf bytes.Buffer
+		for _, rec := range batch {
+			line, err := json.Marshal(rec)
+			if err != nil {
+				return nil, "", err
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), "application/x-ndjson", nil
+	}
+
+	data, err := json.Marshal(batch)
+	return data, "application/json", err
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBatchDispatcherFlushOnCount vérifie qu'un lot est envoyé dès que le nombre maximal est atteint
+func TestBatchDispatcherFlushOnCount(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]SpoolRecord
+	loggerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []SpoolRecord
+		json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer loggerServer.Close()
+
+	b := newBatchDispatcher(Config{
+		BatchEndpoint:   loggerServer.URL,
+		BatchFormat:     BatchFormatJSON,
+		BatchMaxCount:   2,
+		BatchMaxBytes:   1024 * 1024,
+		BatchMaxLatency: time.Hour,
+	}, http.DefaultClient, nil)
+
+	for i := 0; i < 4; i++ {
+		b.Add(SpoolRecord{Action: "create", Entry: json.RawMessage(`{}`)})
+	}
+	b.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, batches, 2, "Deux lots de deux entrées doivent être envoyés")
+	for _, batch := range batches {
+		assert.Len(t, batch, 2)
+	}
+}
+
+// TestBatchDispatcherRetriesPartialFailure vérifie que seuls les éléments en échec sont renvoyés
+func TestBatchDispatcherRetriesPartialFailure(t *testing.T) {
+	var mu sync.Mutex
+	var calls [][]SpoolRecord
+	loggerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []SpoolRecord
+		json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		calls = append(calls, batch)
+		first := len(calls) == 1
+		mu.Unlock()
+
+		if first {
+			w.WriteHeader(http.StatusMultiStatus)
+			w.Write([]byte(`{"errors":[{"index":1,"status":503},{"index":2,"status":400,"message":"invalide"}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer loggerServer.Close()
+
+	b := newBatchDispatcher(Config{
+		BatchEndpoint:   loggerServer.URL,
+		BatchMaxCount:   3,
+		BatchMaxBytes:   1024 * 1024,
+		BatchMaxLatency: time.Hour,
+		MaxRetries:      2,
+		RetryDelay:      time.Millisecond,
+	}, http.DefaultClient, nil)
+
+	b.Add(SpoolRecord{Action: "create", Entry: json.RawMessage(`{"id":"a"}`)})
+	b.Add(SpoolRecord{Action: "create", Entry: json.RawMessage(`{"id":"b"}`)})
+	b.Add(SpoolRecord{Action: "create", Entry: json.RawMessage(`{"id":"c"}`)})
+	b.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, calls, 2, "Le lot doit être renvoyé une seule fois")
+	assert.Len(t, calls[1], 1, "Seul l'élément en erreur 5xx doit être renvoyé")
+	assert.JSONEq(t, `{"id":"b"}`, string(calls[1][0].Entry))
+}
+
+// TestBatchDispatcherSpoolsOnFailure vérifie que les lots non livrés sont transmis au spool
+func TestBatchDispatcherSpoolsOnFailure(t *testing.T) {
+	loggerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer loggerServer.Close()
+
+	var failed []SpoolRecord
+	b := newBatchDispatcher(Config{
+		BatchEndpoint:   loggerServer.URL,
+		BatchFormat:     BatchFormatNDJSON,
+		BatchMaxCount:   10,
+		BatchMaxBytes:   1024 * 1024,
+		BatchMaxLatency: 10 * time.Millisecond,
+		MaxRetries:      1,
+		RetryDelay:      time.Millisecond,
+	}, http.DefaultClient, func(records []SpoolRecord) { failed = append(failed, records...) })
+
+	b.Add(SpoolRecord{Action: "update", Entry: json.RawMessage(`{}`)})
+	b.Close()
+
+	assert.Len(t, failed, 1, "L'entrée non livrée doit être transmise au spool")
+}
+
+// TestBatchDispatcherIsolatesRejectedRecords vérifie qu'un refus 4xx global n'abandonne que l'élément fautif
+func TestBatchDispatcherIsolatesRejectedRecords(t *testing.T) {
+	var mu sync.Mutex
+	var delivered []string
+	loggerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []SpoolRecord
+		json.NewDecoder(r.Body).Decode(&batch)
+		for _, rec := range batch {
+			if string(rec.Entry) == `{"id":"bad"}` {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		mu.Lock()
+		for _, rec := range batch {
+			delivered = append(delivered, string(rec.Entry))
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer loggerServer.Close()
+
+	b := newBatchDispatcher(Config{
+		BatchEndpoint:   loggerServer.URL,
+		BatchMaxCount:   3,
+		BatchMaxBytes:   1024 * 1024,
+		BatchMaxLatency: time.Hour,
+		RetryDelay:      time.Millisecond,
+	}, http.DefaultClient, nil)
+
+	b.Add(SpoolRecord{Action: "create", Entry: json.RawMessage(`{"id":"a"}`)})
+	b.Add(SpoolRecord{Action: "create", Entry: json.RawMessage(`{"id":"bad"}`)})
+	b.Add(SpoolRecord{Action: "create", Entry: json.RawMessage(`{"id":"c"}`)})
+	b.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.ElementsMatch(t, []string{`{"id":"a"}`, `{"id":"c"}`}, delivered, "Seul l'élément refusé doit être abandonné")
+}
+
+// TestBatchDispatcherSendDoesNotBlockAdd vérifie qu'un envoi lent ne bloque pas l'accumulation
+func TestBatchDispatcherSendDoesNotBlockAdd(t *testing.T) {
+	release := make(chan struct{})
+	loggerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer loggerServer.Close()
+
+	b := newBatchDispatcher(Config{
+		BatchEndpoint:   loggerServer.URL,
+		BatchMaxCount:   1,
+		BatchMaxBytes:   1024 * 1024,
+		BatchMaxLatency: time.Hour,
+	}, http.DefaultClient, nil)
+
+	added := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			b.Add(SpoolRecord{Action: "create", Entry: json.RawMessage(`{}`)})
+		}
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Error("Add ne doit pas attendre la fin des envois en cours")
+	}
+	close(release)
+	b.Close()
+}
+
+// TestBatchDispatcherKeepsFlowOrder vérifie qu'un lot attend l'envoi du lot en vol contenant le même flux
+func TestBatchDispatcherKeepsFlowOrder(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	loggerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []SpoolRecord
+		json.NewDecoder(r.Body).Decode(&batch)
+		// La création est lente: sans ordre par flux, la mise à jour arriverait avant
+		if batch[0].Action == "create" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		mu.Lock()
+		for _, rec := range batch {
+			received = append(received, rec.Action+" "+string(rec.Entry))
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer loggerServer.Close()
+
+	b := newBatchDispatcher(Config{
+		BatchEndpoint:   loggerServer.URL,
+		BatchMaxCount:   1,
+		BatchMaxBytes:   1024 * 1024,
+		BatchMaxLatency: time.Hour,
+	}, http.DefaultClient, nil)
+
+	b.Add(SpoolRecord{Action: "create", Entry: json.RawMessage(`{"id":"a"}`)})
+	b.Add(SpoolRecord{Action: "update", Entry: json.RawMessage(`{"id":"a"}`)})
+	b.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{`create {"id":"a"}`, `update {"id":"a"}`}, received, "La mise à jour doit suivre la création")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/base64"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encodages possibles d'un corps journalisé
+const (
+	BodyEncodingText    = ""        // texte UTF-8 tel quel
+	BodyEncodingBase64  = "base64"  // contenu binaire encodé en base64
+	BodyEncodingOmitted = "omitted" // contenu non capturé (type de contenu exclu)
+)
+
+// capturedBody - Corps prêt à être journalisé
+type capturedBody struct {
+	Body      string
+	Encoding  string
+	Size      int // taille d'origine en octets
+	Truncated bool
+}
+
+// captureBody - Préparer un corps pour la journalisation
+//
+// Les types de contenu exclus ne sont pas capturés. Les corps texte sont
+// masqués sur leur intégralité puis tronqués à maxBytes (-1 = sans limite);
+// les corps binaires sont tronqués puis encodés en base64.
+func (r *ruleSet) captureBody(u *url.URL, contentType string, raw []byte, maxBytes int, piiCounts map[string]int) capturedBody {
+	captured := capturedBody{Size: len(raw)}
+	if len(raw) == 0 {
+		return captured
+	}
+
+	if r.skipContentType(contentType) {
+		captured.Encoding = BodyEncodingOmitted
+		return captured
+	}
+
+	if !utf8.Valid(raw) {
+		if maxBytes >= 0 && len(raw) > maxBytes {
+			raw = raw[:maxBytes]
+			captured.Truncated = true
+		}
+		captured.Body = base64.StdEncoding.EncodeToString(raw)
+		captured.Encoding = BodyEncodingBase64
+		return captured
+	}
+
+	text := r.sanitizeBody(u, contentType, raw, piiCounts)
+	if maxBytes >= 0 && len(text) > maxBytes {
+		text = truncateUTF8(text, maxBytes)
+		captured.Truncated = true
+	}
+	captured.Body = string(text)
+	return captured
+}
+
+// skipContentType - Indiquer si le type de contenu est exclu de la capture
+func (r *ruleSet) skipContentType(contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	for _, pattern := range r.source.SkipBodyContentTypes {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateUTF8 - Tronquer un texte sans couper un caractère multi-octets
+func truncateUTF8(text []byte, maxBytes int) []byte {
+	if len(text) <= maxBytes {
+		return text
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCaptureBodyTruncatesText vérifie la troncature des corps texte sans couper un caractère
+func TestCaptureBodyTruncatesText(t *testing.T) {
+	handler, _ := newTestHandler(Config{})
+	defer handler.Close()
+	u, _ := url.Parse("http://example.com/upload")
+
+	captured := handler.rules.Load().captureBody(u, "text/plain", []byte("héllo wörld"), 2, map[string]int{})
+	assert.Equal(t, "h", captured.Body, "La troncature ne doit pas couper un caractère multi-octets")
+	assert.True(t, captured.Truncated)
+	assert.Equal(t, len("héllo wörld"), captured.Size, "La taille d'origine doit être conservée")
+	assert.Equal(t, BodyEncodingText, captured.Encoding)
+
+	captured = handler.rules.Load().captureBody(u, "text/plain", []byte(strings.Repeat("a", 10)), -1, map[string]int{})
+	assert.False(t, captured.Truncated, "Une limite de -1 ne doit pas tronquer")
+}
+
+// TestCaptureBodyEncodesBinary vérifie que les corps non UTF-8 sont encodés en base64
+func TestCaptureBodyEncodesBinary(t *testing.T) {
+	handler, _ := newTestHandler(Config{})
+	defer handler.Close()
+	u, _ := url.Parse("http://example.com/file")
+
+	raw := []byte{0xff, 0xfe, 0x00, 0x01, 0x02}
+	captured := handler.rules.Load().captureBody(u, "application/octet-stream", raw, 3, map[string]int{})
+	assert.Equal(t, BodyEncodingBase64, captured.Encoding)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(raw[:3]), captured.Body)
+	assert.True(t, captured.Truncated)
+	assert.Equal(t, 5, captured.Size)
+}
+
+// TestCaptureBodySkipsMediaTypes vérifie que les images et vidéos ne sont pas capturées
+func TestCaptureBodySkipsMediaTypes(t *testing.T) {
+	handler, _ := newTestHandler(Config{})
+	defer handler.Close()
+	u, _ := url.Parse("http://example.com/logo.png")
+
+	captured := handler.rules.Load().captureBody(u, "image/png", []byte("\x89PNG...."), 1024, map[string]int{})
+	assert.Equal(t, BodyEncodingOmitted, captured.Encoding)
+	assert.Empty(t, captured.Body)
+	assert.Equal(t, 8, captured.Size)
+
+	captured = handler.rules.Load().captureBody(u, "application/json; charset=utf-8", []byte(`{"a":1}`), 1024, map[string]int{})
+	assert.Equal(t, `{"a":1}`, captured.Body, "Les contenus texte doivent être conservés")
+}
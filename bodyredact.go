@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// BodyRedactRule - Champs JSON à masquer dans les corps d'un hôte et d'une route
+//
+// Host et Route sont des globs (vide = tous). Route accepte "**" en fin de
+// motif pour couvrir toute une arborescence ("/api/**"). Fields contient des
+// sélecteurs:
+//   - "password"        : clé présente à n'importe quelle profondeur
+//   - "$.card.number"   : chemin absolu depuis la racine
+//   - "**.secret"       : "**" couvre zéro ou plusieurs niveaux
+//   - "$.items[*].token": "*" couvre une clé quelconque; les index de tableau sont ignorés
+type BodyRedactRule struct {
+	Host   string   `yaml:"host"`
+	Route  string   `yaml:"route"`
+	Fields []string `yaml:"fields"`
+}
+
+// bodyRedactor - Sélecteurs compilés par règle
+type bodyRedactor struct {
+	rules []compiledBodyRule
+}
+
+type compiledBodyRule struct {
+	host      string
+	route     string
+	selectors [][]string
+}
+
+// newBodyRedactor - Compiler les règles de masquage des corps JSON
+func newBodyRedactor(rules []BodyRedactRule) *bodyRedactor {
+	r := &bodyRedactor{}
+	for _, rule := range rules {
+		compiled := compiledBodyRule{
+			host:  strings.ToLower(strings.TrimSpace(rule.Host)),
+			route: strings.TrimSpace(rule.Route),
+		}
+		for _, field := range rule.Fields {
+			if selector := parseJSONSelector(field); selector != nil {
+				compiled.selectors = append(compiled.selectors, selector)
+			}
+		}
+		if len(compiled.selectors) > 0 {
+			r.rules = append(r.rules, compiled)
+		}
+	}
+	return r
+}
+
+// parseBodyRedactRules - Lire des règles au format "hôte/route=champ|champ;..."
+func parseBodyRedactRules(spec string) ([]BodyRedactRule, error) {
+	var rules []BodyRedactRule
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		target, fields, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("règle de masquage de corps invalide %q: \"=\" attendu", part)
+		}
+
+		rule := BodyRedactRule{Fields: strings.Split(fields, "|")}
+		if i := strings.Index(target, "/"); i >= 0 {
+			rule.Host, rule.Route = target[:i], target[i:]
+		} else {
+			rule.Host = target
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseJSONSelector - Découper un sélecteur en segments de clés
+func parseJSONSelector(field string) []string {
+	field = strings.TrimSpace(field)
+	if field == "" {
+		return nil
+	}
+
+	absolute := strings.HasPrefix(field, "$")
+	field = strings.TrimPrefix(strings.TrimPrefix(field, "$"), ".")
+
+	var segments []string
+	if !absolute {
+		// Une clé simple (ou un chemin relatif) peut apparaître à n'importe quelle profondeur
+		segments = append(segments, "**")
+	}
+	for _, segment := range strings.Split(field, ".") {
+		// Ignorer les index de tableau: "items[*]" -> "items"
+		if i := strings.Index(segment, "["); i >= 0 {
+			segment = segment[:i]
+		}
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 || (len(segments) == 1 && segments[0] == "**") {
+		return nil
+	}
+	return segments
+}
+
+// selectors - Sélecteurs applicables à un hôte et un chemin
+func (r *bodyRedactor) selectors(host, urlPath string) [][]string {
+	host = strings.ToLower(host)
+	var selectors [][]string
+	for _, rule := range r.rules {
+		if rule.host != "" && !matchGlob(rule.host, host) {
+			continue
+		}
+		if rule.route != "" && !matchGlob(rule.route, urlPath) {
+			continue
+		}
+		selectors = append(selectors, rule.selectors...)
+	}
+	return selectors
+}
+
+// Redact - Masquer les champs sélectionnés d'un corps JSON
+//
+// Le corps est retourné tel quel s'il n'est pas du JSON ou si aucun champ
+// ne correspond.
+func (r *bodyRedactor) Redact(host, urlPath string, body []byte) []byte {
+	selectors := r.selectors(host, urlPath)
+	if len(selectors) == 0 {
+		return body
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return body
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return body
+	}
+
+	if !redactJSONValue(doc, nil, selectors) {
+		return body
+	}
+	redacted, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+// redactJSONValue - Parcourir l'arbre JSON et masquer les clés sélectionnées
+func redactJSONValue(value interface{}, keys []string, selectors [][]string) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			childKeys := append(keys[:len(keys):len(keys)], key)
+			if matchesAnySelector(selectors, childKeys) {
+				v[key] = maskedValue
+				changed = true
+				continue
+			}
+			if redactJSONValue(child, childKeys, selectors) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		// Les index de tableau ne font pas partie du chemin
+		for _, child := range v {
+			if redactJSONValue(child, keys, selectors) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func matchesAnySelector(selectors [][]string, keys []string) bool {
+	for _, selector := range selectors {
+		if matchSelector(selector, keys) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSelector - Comparer un chemin de clés à un sélecteur ("*" et "**" acceptés)
+func matchSelector(selector, keys []string) bool {
+	if len(selector) == 0 {
+		return len(keys) == 0
+	}
+	switch selector[0] {
+	case "**":
+		for i := 0; i <= len(keys); i++ {
+			if matchSelector(selector[1:], keys[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(keys) > 0 && matchSelector(selector[1:], keys[1:])
+	default:
+		return len(keys) > 0 && strings.EqualFold(selector[0], keys[0]) && matchSelector(selector[1:], keys[1:])
+	}
+}
+
+// matchGlob - Correspondance glob, "**" final couvrant toute la suite
+func matchGlob(pattern, s string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "**"); ok {
+		return strings.HasPrefix(s, prefix)
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBodyRedactorSelectors vérifie les différents sélecteurs de champs JSON
+func TestBodyRedactorSelectors(t *testing.T) {
+	redactor := newBodyRedactor([]BodyRedactRule{
+		{Fields: []string{"password", "$.card.number", "**.secret", "$.items[*].token"}},
+	})
+
+	body := []byte(`{"user":"jdoe","password":"p@ss","card":{"number":"4111","expiry":"12/30"},` +
+		`"nested":{"deep":{"secret":42}},"items":[{"token":"t1","id":1},{"token":"t2","id":2}],"number":"visible"}`)
+
+	redacted := redactor.Redact("api.example.com", "/login", body)
+	assert.JSONEq(t, `{"user":"jdoe","password":"********","card":{"number":"********","expiry":"12/30"},`+
+		`"nested":{"deep":{"secret":"********"}},"items":[{"token":"********","id":1},{"token":"********","id":2}],"number":"visible"}`,
+		string(redacted), "Seuls les champs sélectionnés doivent être masqués")
+}
+
+// TestBodyRedactorScopedRules vérifie que les règles ne s'appliquent qu'à leur hôte et leur route
+func TestBodyRedactorScopedRules(t *testing.T) {
+	rules, err := parseBodyRedactRules("api.example.com/login=password;*/oauth/**=access_token")
+	assert.NoError(t, err)
+	redactor := newBodyRedactor(rules)
+
+	body := []byte(`{"password":"p@ss","access_token":"abc"}`)
+
+	assert.JSONEq(t, `{"password":"********","access_token":"abc"}`,
+		string(redactor.Redact("api.example.com", "/login", body)))
+	assert.JSONEq(t, `{"password":"p@ss","access_token":"********"}`,
+		string(redactor.Redact("auth.example.com", "/oauth/v2/token", body)))
+	assert.Equal(t, string(body), string(redactor.Redact("other.example.com", "/login", body)),
+		"Le corps doit rester intact hors du périmètre des règles")
+}
+
+// TestBodyRedactorIgnoresNonJSON vérifie que les corps non JSON sont laissés intacts
+func TestBodyRedactorIgnoresNonJSON(t *testing.T) {
+	redactor := newBodyRedactor([]BodyRedactRule{{Fields: []string{"password"}}})
+
+	body := []byte("password=p@ss&user=jdoe")
+	assert.Equal(t, body, redactor.Redact("example.com", "/", body))
+
+	invalid := []byte(`{"password":`)
+	assert.Equal(t, invalid, redactor.Redact("example.com", "/", invalid))
+}
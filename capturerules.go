@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// Actions des règles de capture
+const (
+	CaptureSkip     = "skip"     // flux non journalisé
+	CaptureMetadata = "metadata" // flux journalisé sans les corps
+	CaptureFull     = "full"     // flux journalisé avec les corps
+)
+
+// CaptureRule - Règle de capture d'un flux
+//
+// Tous les critères renseignés doivent correspondre (vide = tous):
+//   - Host: glob sur le nom d'hôte, sans le port ("*.example.com")
+//   - Path: glob par segments, "*" couvrant un segment et "**" zéro ou
+//     plusieurs ("/api/**/health"), ou expression régulière préfixée par "re:"
+//   - Methods: méthodes HTTP acceptées
+//   - Headers: en-têtes de requête devant être présents
+//   - Client: glob sur le nom du client
+//
+// Les règles sont évaluées dans l'ordre et la première qui correspond
+// l'emporte: une inclusion placée avant une exclusion plus large est donc
+// prioritaire.
+type CaptureRule struct {
+	Host    string   `yaml:"host"`
+	Path    string   `yaml:"path"`
+	Methods []string `yaml:"methods"`
+	Headers []string `yaml:"headers"`
+	Client  string   `yaml:"client"`
+	Action  string   `yaml:"action"` // "skip", "metadata" ou "full"
+}
+
+// compiledCaptureRule - Règle de capture prête à l'évaluation
+type compiledCaptureRule struct {
+	host    string
+	path    []string
+	pathRe  *regexp.Regexp
+	methods []string
+	headers []string
+	client  string
+	action  string
+}
+
+// compileCaptureRule - Vérifier et compiler une règle de capture
+func compileCaptureRule(rule CaptureRule) (compiledCaptureRule, error) {
+	compiled := compiledCaptureRule{
+		host:    strings.ToLower(strings.TrimSpace(rule.Host)),
+		methods: rule.Methods,
+		headers: rule.Headers,
+		client:  strings.TrimSpace(rule.Client),
+		action:  strings.ToLower(strings.TrimSpace(rule.Action)),
+	}
+	if !oneOf(compiled.action, CaptureSkip, CaptureMetadata, CaptureFull) {
+		return compiled, fmt.Errorf("action inconnue %q", rule.Action)
+	}
+	for _, glob := range []string{compiled.host, compiled.client} {
+		if _, err := path.Match(glob, ""); err != nil {
+			return compiled, fmt.Errorf("glob invalide %q", glob)
+		}
+	}
+
+	rulePath := strings.TrimSpace(rule.Path)
+	if expr, ok := strings.CutPrefix(rulePath, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return compiled, fmt.Errorf("expression régulière invalide %q: %w", expr, err)
+		}
+		compiled.pathRe = re
+	} else if rulePath != "" {
+		compiled.path = splitPath(rulePath)
+		for _, segment := range compiled.path {
+			if _, err := path.Match(segment, ""); err != nil {
+				return compiled, fmt.Errorf("glob invalide %q", rulePath)
+			}
+		}
+	}
+	return compiled, nil
+}
+
+// parseCaptureRules - Lire des règles au format "hôte/chemin=action;..."
+func parseCaptureRules(spec string) ([]CaptureRule, error) {
+	var rules []CaptureRule
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		target, action, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("règle de capture invalide %q: \"=\" attendu", part)
+		}
+
+		rule := CaptureRule{Action: action}
+		if i := strings.Index(target, "/"); i >= 0 {
+			rule.Host, rule.Path = target[:i], target[i:]
+		} else {
+			rule.Host = target
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// excludedRouteRules - Convertir une route de ExcludedRoutes en règles d'exclusion
+//
+// La route désigne des segments entiers du chemin: "health" exclut
+// "/health" et "/api/health/live" mais plus "/api/healthcare-claims".
+// Une route qui désigne clairement un hôte (schéma, port ou "hôte/chemin",
+// par exemple "https://example.com/health" ou "example.com:8443") ne
+// correspond qu'à cet hôte et ses sous-domaines, le chemin éventuel étant
+// alors ancré à la racine. Une route à point seule ("favicon.ico",
+// "tracker.example.com") reste ambiguë: elle exclut le segment de chemin et
+// l'hôte.
+func excludedRouteRules(route string) []CaptureRule {
+	route = strings.TrimSpace(route)
+	hasScheme := false
+	if i := strings.Index(route, "://"); i >= 0 {
+		route = route[i+3:]
+		hasScheme = true
+	}
+	leadingSlash := strings.HasPrefix(route, "/")
+	route = strings.Trim(route, "/")
+	if route == "" {
+		return nil
+	}
+
+	pathRule := CaptureRule{Path: "**/" + route + "/**", Action: CaptureSkip}
+	host, rest, hasPath := strings.Cut(route, "/")
+	dotted := strings.Contains(host, ".")
+	clearlyHost := !leadingSlash && (hasScheme || hasPort(host) || hasPath && dotted)
+	if !clearlyHost && (leadingSlash || !dotted) {
+		return []CaptureRule{pathRule}
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	rulePath := ""
+	if rest != "" {
+		rulePath = "/" + rest + "/**"
+	}
+	rules := []CaptureRule{
+		{Host: host, Path: rulePath, Action: CaptureSkip},
+		{Host: "*." + host, Path: rulePath, Action: CaptureSkip},
+	}
+	if !clearlyHost {
+		rules = append(rules, pathRule)
+	}
+	return rules
+}
+
+// hasPort - Indiquer si un hôte se termine par un port numérique
+func hasPort(host string) bool {
+	_, port, err := net.SplitHostPort(host)
+	if err != nil || port == "" {
+		return false
+	}
+	_, err = strconv.Atoi(port)
+	return err == nil
+}
+
+// matches - Indiquer si une requête satisfait tous les critères de la règle
+func (r *compiledCaptureRule) matches(req *proxy.Request, clientName string) bool {
+	if r.host != "" {
+		if ok, _ := path.Match(r.host, strings.ToLower(req.URL.Hostname())); !ok {
+			return false
+		}
+	}
+	if r.pathRe != nil && !r.pathRe.MatchString(req.URL.Path) {
+		return false
+	}
+	if r.path != nil && !matchSegments(r.path, splitPath(req.URL.Path)) {
+		return false
+	}
+	if len(r.methods) > 0 && !containsFold(r.methods, req.Method) {
+		return false
+	}
+	for _, name := range r.headers {
+		if len(req.Header.Values(name)) == 0 {
+			return false
+		}
+	}
+	if r.client != "" {
+		if ok, _ := path.Match(r.client, clientName); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPath - Découper un chemin en segments non vides
+func splitPath(p string) []string {
+	return strings.FieldsFunc(p, func(r rune) bool { return r == '/' })
+}
+
+// matchSegments - Correspondance de segments de chemin avec un glob par segments
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segments[0])
+	return ok && matchSegments(pattern[1:], segments[1:])
+}
+
+// containsFold - Indiquer si une liste contient une valeur, sans tenir compte de la casse
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// omittedBody - Corps d'un flux journalisé sans ses corps (action "metadata")
+func omittedBody(raw []byte) capturedBody {
+	captured := capturedBody{Size: len(raw)}
+	if len(raw) > 0 {
+		captured.Encoding = BodyEncodingOmitted
+	}
+	return captured
+}
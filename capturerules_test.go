@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExcludedRoutesMatchWholeSegments vérifie qu'une route exclue ne couvre que des segments entiers
+func TestExcludedRoutesMatchWholeSegments(t *testing.T) {
+	rules, err := compileRules(ruleConfig{ExcludedRoutes: []string{"health", "", "/metrics"}})
+	assert.NoError(t, err)
+
+	cases := map[string]string{
+		"http://example.com/health":                CaptureSkip,
+		"http://example.com/api/health/live":       CaptureSkip,
+		"http://example.com/metrics?format=json":   CaptureSkip,
+		"http://example.com/api/healthcare-claims": CaptureFull,
+		"http://example.com/api/metrics":           CaptureSkip,
+		"http://example.com/api/users":             CaptureFull,
+	}
+	for rawURL, want := range cases {
+		req := newTestFlow("GET", rawURL, nil).Request
+		assert.Equal(t, want, rules.captureAction(req, "Anonyme"), "Action inattendue pour %s", rawURL)
+	}
+}
+
+// TestExcludedRoutesKeepHostMatching vérifie qu'une route historique désignant un hôte exclut toujours cet hôte
+func TestExcludedRoutesKeepHostMatching(t *testing.T) {
+	rules, err := compileRules(ruleConfig{ExcludedRoutes: []string{"tracker.example.com", "https://api.example.org:8443/health"}})
+	assert.NoError(t, err)
+
+	cases := map[string]string{
+		"http://tracker.example.com/collect":        CaptureSkip,
+		"https://eu.tracker.example.com/":           CaptureSkip,
+		"https://api.example.org/health/live":       CaptureSkip,
+		"https://api.example.org/users":             CaptureFull,
+		"http://example.com/tracker.example.com":    CaptureSkip,
+		"https://other.example.com/api/health/live": CaptureFull,
+	}
+	for rawURL, want := range cases {
+		req := newTestFlow("GET", rawURL, nil).Request
+		assert.Equal(t, want, rules.captureAction(req, "Anonyme"), "Action inattendue pour %s", rawURL)
+	}
+}
+
+// TestExcludedRoutesKeepDottedPaths vérifie qu'une route à point qui n'est pas clairement un hôte exclut toujours le chemin
+func TestExcludedRoutesKeepDottedPaths(t *testing.T) {
+	rules, err := compileRules(ruleConfig{ExcludedRoutes: []string{"favicon.ico", "metrics.json", "/static/app.js"}})
+	assert.NoError(t, err)
+
+	cases := map[string]string{
+		"http://example.com/favicon.ico":         CaptureSkip,
+		"https://example.com/assets/favicon.ico": CaptureSkip,
+		"http://example.com/metrics.json":        CaptureSkip,
+		"http://example.com/api/metrics.json":    CaptureSkip,
+		"http://example.com/static/app.js":       CaptureSkip,
+		"http://example.com/metrics":             CaptureFull,
+		"http://example.com/favicon.png":         CaptureFull,
+		"http://app.js/users":                    CaptureFull,
+	}
+	for rawURL, want := range cases {
+		req := newTestFlow("GET", rawURL, nil).Request
+		assert.Equal(t, want, rules.captureAction(req, "Anonyme"), "Action inattendue pour %s", rawURL)
+	}
+}
+
+// TestCaptureRulesCriteriaAndPrecedence vérifie les critères et la priorité de la première règle
+func TestCaptureRulesCriteriaAndPrecedence(t *testing.T) {
+	rules, err := compileRules(ruleConfig{
+		CaptureRules: []CaptureRule{
+			{Host: "*.example.com", Path: "/admin/**", Methods: []string{"post"}, Action: CaptureFull},
+			{Path: "/admin/**", Action: CaptureSkip},
+			{Path: "re:^/files/[0-9]+$", Action: CaptureMetadata},
+			{Headers: []string{"X-Debug"}, Client: "test-*", Action: CaptureSkip},
+		},
+		CaptureDefault: CaptureFull,
+	})
+	assert.NoError(t, err)
+
+	request := func(method, rawURL string, header ...string) *proxy.Request {
+		req := newTestFlow(method, rawURL, nil).Request
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		return req
+	}
+
+	assert.Equal(t, CaptureFull, rules.captureAction(request("POST", "http://api.example.com:8443/admin/users"), "Anonyme"),
+		"L'inclusion placée en premier doit l'emporter sur l'exclusion")
+	assert.Equal(t, CaptureSkip, rules.captureAction(request("GET", "http://api.example.com/admin/users"), "Anonyme"))
+	assert.Equal(t, CaptureSkip, rules.captureAction(request("POST", "http://example.com/admin"), "Anonyme"),
+		"Le glob d'hôte ne doit pas couvrir le domaine parent")
+	assert.Equal(t, CaptureMetadata, rules.captureAction(request("GET", "http://example.com/files/42"), "Anonyme"))
+	assert.Equal(t, CaptureFull, rules.captureAction(request("GET", "http://example.com/files/42/meta"), "Anonyme"))
+	assert.Equal(t, CaptureSkip, rules.captureAction(request("GET", "http://example.com/", "X-Debug", "1"), "test-ci"))
+	assert.Equal(t, CaptureFull, rules.captureAction(request("GET", "http://example.com/", "X-Debug", "1"), "prod"),
+		"Tous les critères de la règle doivent correspondre")
+}
+
+// TestCaptureDefaultAllowList vérifie une liste d'inclusion avec exclusion par défaut
+func TestCaptureDefaultAllowList(t *testing.T) {
+	rules, err := compileRules(ruleConfig{
+		CaptureRules:   []CaptureRule{{Host: "api.example.com", Action: CaptureFull}},
+		CaptureDefault: CaptureSkip,
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, CaptureFull, rules.captureAction(newTestFlow("GET", "http://api.example.com/x", nil).Request, "Anonyme"))
+	assert.Equal(t, CaptureSkip, rules.captureAction(newTestFlow("GET", "http://cdn.example.com/x", nil).Request, "Anonyme"))
+}
+
+// TestCaptureRulesInvalid vérifie le rejet des règles invalides et la lecture du format compact
+func TestCaptureRulesInvalid(t *testing.T) {
+	for _, rule := range []CaptureRule{
+		{Path: "/api", Action: "ignore"},
+		{Path: "re:(", Action: CaptureSkip},
+		{Host: "[", Action: CaptureSkip},
+	} {
+		_, err := compileCaptureRule(rule)
+		assert.Error(t, err, "La règle %+v doit être refusée", rule)
+	}
+
+	rules, err := parseCaptureRules("*/health=skip; api.example.com/files/**=metadata")
+	assert.NoError(t, err)
+	assert.Equal(t, []CaptureRule{
+		{Host: "*", Path: "/health", Action: CaptureSkip},
+		{Host: "api.example.com", Path: "/files/**", Action: CaptureMetadata},
+	}, rules)
+
+	_, err = parseCaptureRules("/health")
+	assert.Error(t, err, "L'action est obligatoire")
+}
+
+// TestCaptureMetadataOmitsBodies vérifie qu'un flux "metadata" est journalisé sans ses corps
+func TestCaptureMetadataOmitsBodies(t *testing.T) {
+	handler, sink := newTestHandler(Config{
+		CaptureRules: []CaptureRule{{Path: "/files/**", Action: CaptureMetadata}},
+	})
+
+	f := newTestFlow("POST", "http://example.com/files/upload", []byte("contenu du fichier"))
+	handler.Request(f)
+	f.Response = &proxy.Response{StatusCode: 201, Header: make(http.Header), Body: []byte(`{"id":1}`)}
+	handler.Response(f)
+	handler.Close()
+
+	creates := sink.entries(t, "create")
+	assert.Len(t, creates, 1)
+	assert.Equal(t, CaptureMetadata, creates[0].CaptureMode)
+	assert.Empty(t, creates[0].HTTPBody, "Le corps de requête ne doit pas être journalisé")
+	assert.Equal(t, BodyEncodingOmitted, creates[0].HTTPBodyEncoding)
+	assert.Equal(t, 18, creates[0].HTTPBodySize, "La taille du corps doit rester renseignée")
+
+	updates := sink.entries(t, "update")
+	assert.Len(t, updates, 1)
+	assert.Empty(t, updates[0].HTTPReturnBody, "Le corps de réponse ne doit pas être journalisé")
+	assert.Equal(t, 8, updates[0].HTTPReturnBodySize)
+	assert.Equal(t, 201, updates[0].HTTPReturnCode)
+}
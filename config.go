@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfig - Configuration par défaut, avant fichier et variables d'environnement
+func defaultConfig() Config {
+	return Config{
+		LoggerEndpoint: "http://localhost:8080/api/logs",
+		MaxRetries:     3,
+		RetryDelay:     500 * time.Millisecond,
+		MaskHeaders:    []string{"authorization", "password", "token", "api-key"},
+		MaskMode:       MaskModeRedact,
+		CaptureDefault: CaptureFull,
+
+		SampleRate:          1,
+		SampleKeepErrors:    true,
+		SampleByCorrelation: true,
+		SampleOutAction:     CaptureMetadata,
+
+		IdentityClient:      defaultIdentityClient,
+		IdentityUser:        defaultIdentityUser,
+		IdentityCorrelation: defaultIdentityCorrelation,
+		IdentityTenant:      defaultIdentityTenant,
+
+		ProxyAuthRealm: "mitm-proxy",
+
+		MaskQueryParams: []string{"access_token", "apikey", "api_key", "token"},
+		MaskCookies:     []string{"*session*", "*sessid*", "sid", "*token*", "*auth*", "*csrf*", "*xsrf*", "remember*"},
+
+		MaxRequestBody:       64 * 1024,
+		MaxResponseBody:      64 * 1024,
+		SkipBodyContentTypes: []string{"image/*", "video/*", "audio/*", "font/*"},
+
+		DecodeBodies:          true,
+		MaxDecompressedBody:   10 * 1024 * 1024,
+		MaxDecompressionRatio: 100,
+
+		WebInterface: true,
+		ProxyPort:    9080,
+		WebPort:      9081,
+		FlowTTL:      5 * time.Minute,
+
+		ConfigWatchInterval: 5 * time.Second,
+
+		CertExpiryWarnDays: 30,
+
+		SpoolSegmentBytes:  8 * 1024 * 1024,
+		SpoolMaxBytes:      512 * 1024 * 1024,
+		SpoolFsync:         SpoolFsyncInterval,
+		SpoolDrainInterval: time.Second,
+
+		// BatchEndpoint est déduit de LoggerEndpoint par loadConfig
+		BatchFormat:     BatchFormatJSON,
+		BatchMaxCount:   100,
+		BatchMaxBytes:   1024 * 1024,
+		BatchMaxLatency: time.Second,
+
+		// LogQueueOverflow est déduit de SpoolDir par loadConfig (voir defaultOverflowPolicy)
+		LogWorkers:   4,
+		LogQueueSize: 1000,
+
+		Sinks:             []string{SinkLogger},
+		LogFilePath:       "mitm-proxy.jsonl",
+		LogFileMaxBytes:   100 * 1024 * 1024,
+		LogFileMaxBackups: 5,
+	}
+}
+
+// loadConfig - Construire la configuration effective
+//
+// Les valeurs par défaut sont complétées par le fichier YAML ou JSON donné
+// (facultatif), puis par les variables d'environnement. Toute clé inconnue,
+// valeur illisible ou incohérente fait échouer le chargement.
+func loadConfig(path string) (Config, error) {
+	config := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("lecture du fichier de configuration: %w", err)
+		}
+		if err := decodeConfigFile(data, &config); err != nil {
+			return Config{}, fmt.Errorf("fichier de configuration %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnvOverrides(&config); err != nil {
+		return Config{}, err
+	}
+	if config.LogQueueOverflow == "" {
+		config.LogQueueOverflow = defaultOverflowPolicy(config.SpoolDir)
+	}
+	if config.BatchEndpoint == "" {
+		config.BatchEndpoint = fmt.Sprintf("%s/bulk", config.LoggerEndpoint)
+	}
+	if err := validateConfig(config); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// defaultOverflowPolicy - Politique de débordement par défaut, jamais bloquante
+//
+// Bloquer l'envoi retarderait le trafic proxifié lorsque le logger est lent:
+// les entrées débordent dans le spool s'il est configuré, sinon les plus
+// anciennes sont abandonnées.
+func defaultOverflowPolicy(spoolDir string) string {
+	if spoolDir != "" {
+		return OverflowSpill
+	}
+	return OverflowDropOldest
+}
+
+// decodeConfigFile - Lire un fichier YAML ou JSON (le JSON étant du YAML valide)
+func decodeConfigFile(data []byte, config *Config) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// envOverrides - Lecture stricte des variables d'environnement
+//
+// Seules les variables définies remplacent la valeur courante; les erreurs
+// de conversion sont accumulées pour être toutes signalées.
+type envOverrides struct {
+	errs []error
+}
+
+func (e *envOverrides) lookup(key string) (string, bool) {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+func (e *envOverrides) string(key string, dst *string) {
+	if value, ok := e.lookup(key); ok {
+		*dst = value
+	}
+}
+
+func (e *envOverrides) list(key string, dst *[]string) {
+	if value, ok := e.lookup(key); ok {
+		*dst = splitList(value)
+	}
+}
+
+func (e *envOverrides) int(key string, dst *int) {
+	if value, ok := e.lookup(key); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: entier invalide %q", key, value))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envOverrides) int64(key string, dst *int64) {
+	if value, ok := e.lookup(key); ok {
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: entier invalide %q", key, value))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envOverrides) float(key string, dst *float64) {
+	if value, ok := e.lookup(key); ok {
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: nombre invalide %q", key, value))
+			return
+		}
+		*dst = f
+	}
+}
+
+func (e *envOverrides) bool(key string, dst *bool) {
+	if value, ok := e.lookup(key); ok {
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true", "1", "yes":
+			*dst = true
+		case "false", "0", "no":
+			*dst = false
+		default:
+			e.errs = append(e.errs, fmt.Errorf("%s: booléen invalide %q", key, value))
+		}
+	}
+}
+
+func (e *envOverrides) duration(key string, dst *time.Duration) {
+	if value, ok := e.lookup(key); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: durée invalide %q", key, value))
+			return
+		}
+		*dst = d
+	}
+}
+
+// applyEnvOverrides - Appliquer les variables d'environnement définies
+func applyEnvOverrides(c *Config) error {
+	env := &envOverrides{}
+
+	env.string("LOGGER_ENDPOINT", &c.LoggerEndpoint)
+	env.int("MAX_RETRIES", &c.MaxRetries)
+	env.duration("RETRY_DELAY", &c.RetryDelay)
+	env.list("EXCLUDED_ROUTES", &c.ExcludedRoutes)
+	if spec, ok := env.lookup("CAPTURE_RULES"); ok {
+		rules, err := parseCaptureRules(spec)
+		if err != nil {
+			env.errs = append(env.errs, fmt.Errorf("CAPTURE_RULES: %w", err))
+		}
+		c.CaptureRules = rules
+	}
+	env.string("CAPTURE_DEFAULT", &c.CaptureDefault)
+
+	env.float("SAMPLE_RATE", &c.SampleRate)
+	if spec, ok := env.lookup("SAMPLE_RULES"); ok {
+		rules, err := parseSampleRules(spec)
+		if err != nil {
+			env.errs = append(env.errs, fmt.Errorf("SAMPLE_RULES: %w", err))
+		}
+		c.SampleRules = rules
+	}
+	env.bool("SAMPLE_KEEP_ERRORS", &c.SampleKeepErrors)
+	env.duration("SAMPLE_KEEP_SLOW", &c.SampleKeepSlow)
+	env.bool("SAMPLE_BY_CORRELATION", &c.SampleByCorrelation)
+	env.string("SAMPLE_OUT_ACTION", &c.SampleOutAction)
+
+	env.list("IDENTITY_CLIENT", &c.IdentityClient)
+	env.list("IDENTITY_USER", &c.IdentityUser)
+	env.list("IDENTITY_CORRELATION", &c.IdentityCorrelation)
+	env.list("IDENTITY_TENANT", &c.IdentityTenant)
+	env.bool("PROPAGATE_CORRELATION", &c.PropagateCorrelation)
+	env.bool("PROPAGATE_TRACE_CONTEXT", &c.PropagateTraceContext)
+	env.string("PROXY_AUTH_FILE", &c.ProxyAuthFile)
+	env.list("PROXY_AUTH_TOKENS", &c.ProxyAuthTokens)
+	env.string("PROXY_AUTH_REALM", &c.ProxyAuthRealm)
+	env.string("JWT_SECRET", &c.JWTSecret)
+	env.string("JWT_JWKS_FILE", &c.JWTJWKSFile)
+	env.list("MASK_HEADERS", &c.MaskHeaders)
+	env.string("MASK_MODE", &c.MaskMode)
+	env.string("MASK_HASH_KEY", &c.MaskHashKey)
+	env.list("MASK_QUERY_PARAMS", &c.MaskQueryParams)
+	env.list("MASK_COOKIES", &c.MaskCookies)
+
+	if spec, ok := env.lookup("BODY_REDACT_RULES"); ok {
+		rules, err := parseBodyRedactRules(spec)
+		if err != nil {
+			env.errs = append(env.errs, fmt.Errorf("BODY_REDACT_RULES: %w", err))
+		}
+		c.BodyRedactRules = rules
+	}
+	if fields, ok := env.lookup("BODY_REDACT_FIELDS"); ok {
+		c.BodyRedactRules = append(c.BodyRedactRules, BodyRedactRule{Fields: splitList(fields)})
+	}
+	env.list("PII_DETECTORS", &c.PIIDetectors)
+
+	env.int("MAX_REQUEST_BODY", &c.MaxRequestBody)
+	env.int("MAX_RESPONSE_BODY", &c.MaxResponseBody)
+	env.list("SKIP_BODY_CONTENT_TYPES", &c.SkipBodyContentTypes)
+	env.bool("DECODE_BODIES", &c.DecodeBodies)
+	env.int("MAX_DECOMPRESSED_BODY", &c.MaxDecompressedBody)
+	env.int("MAX_DECOMPRESSION_RATIO", &c.MaxDecompressionRatio)
+
+	env.bool("WEB_INTERFACE", &c.WebInterface)
+	env.int("PROXY_PORT", &c.ProxyPort)
+	env.int("WEB_PORT", &c.WebPort)
+	env.int("METRICS_PORT", &c.MetricsPort)
+	env.duration("FLOW_TTL", &c.FlowTTL)
+	env.duration("CONFIG_WATCH_INTERVAL", &c.ConfigWatchInterval)
+
+	env.bool("LOG_CONNECTIONS", &c.LogConnections)
+	env.int("CERT_EXPIRY_WARN_DAYS", &c.CertExpiryWarnDays)
+
+	env.string("SPOOL_DIR", &c.SpoolDir)
+	env.int64("SPOOL_SEGMENT_BYTES", &c.SpoolSegmentBytes)
+	env.int64("SPOOL_MAX_BYTES", &c.SpoolMaxBytes)
+	env.string("SPOOL_FSYNC", &c.SpoolFsync)
+	env.duration("SPOOL_DRAIN_INTERVAL", &c.SpoolDrainInterval)
+
+	env.bool("BATCH_ENABLED", &c.BatchEnabled)
+	env.string("BATCH_ENDPOINT", &c.BatchEndpoint)
+	env.string("BATCH_FORMAT", &c.BatchFormat)
+	env.int("BATCH_MAX_COUNT", &c.BatchMaxCount)
+	env.int("BATCH_MAX_BYTES", &c.BatchMaxBytes)
+	env.duration("BATCH_MAX_LATENCY", &c.BatchMaxLatency)
+
+	env.int("LOG_WORKERS", &c.LogWorkers)
+	env.int("LOG_QUEUE_SIZE", &c.LogQueueSize)
+	env.string("LOG_QUEUE_OVERFLOW", &c.LogQueueOverflow)
+
+	env.list("LOG_SINKS", &c.Sinks)
+	env.string("LOG_FILE_PATH", &c.LogFilePath)
+	env.int64("LOG_FILE_MAX_BYTES", &c.LogFileMaxBytes)
+	env.int("LOG_FILE_MAX_BACKUPS", &c.LogFileMaxBackups)
+
+	return errors.Join(env.errs...)
+}
+
+// validateConfig - Vérifier la cohérence de la configuration
+func validateConfig(c Config) error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if sinkEnabled(c.Sinks, SinkLogger) {
+		if err := validateEndpoint(c.LoggerEndpoint); err != nil {
+			fail("logger_endpoint: %v", err)
+		}
+	}
+	if c.BatchEnabled || c.BatchEndpoint != "" {
+		if err := validateEndpoint(c.BatchEndpoint); err != nil {
+			fail("batch_endpoint: %v", err)
+		}
+	}
+	if c.MaxRetries < 0 {
+		fail("max_retries: doit être positif ou nul (%d)", c.MaxRetries)
+	}
+	if c.RetryDelay < 0 {
+		fail("retry_delay: doit être positif ou nul (%s)", c.RetryDelay)
+	}
+
+	// Règles de capture
+	for i, rule := range c.CaptureRules {
+		if _, err := compileCaptureRule(rule); err != nil {
+			fail("capture_rules[%d]: %v", i, err)
+		}
+	}
+	if !oneOf(c.CaptureDefault, CaptureSkip, CaptureMetadata, CaptureFull) {
+		fail("capture_default: action inconnue %q", c.CaptureDefault)
+	}
+
+	// Échantillonnage
+	if _, err := newSampler(c); err != nil {
+		fail("échantillonnage: %v", err)
+	}
+	if c.SampleKeepSlow < 0 {
+		fail("sample_keep_slow: doit être positif ou nul (%s)", c.SampleKeepSlow)
+	}
+
+	// Identité
+	if _, err := newIdentityExtractor(c); err != nil {
+		fail("identité: %v", err)
+	}
+	if len(c.IdentityClient) == 0 {
+		fail("identity_client: au moins une source est requise (static:<valeur> pour une valeur fixe)")
+	}
+	if len(c.IdentityUser) == 0 {
+		fail("identity_user: au moins une source est requise (static:<valeur> pour une valeur fixe)")
+	}
+	if _, err := newProxyAuthenticator(c); err != nil {
+		fail("authentification au proxy: %v", err)
+	}
+	if (c.ProxyAuthFile != "" || len(c.ProxyAuthTokens) > 0) && c.ProxyAuthRealm == "" {
+		fail("proxy_auth_realm: requis avec l'authentification au proxy")
+	}
+
+	// Règles de masquage
+	if !oneOf(c.MaskMode, MaskModeRedact, MaskModePartial, MaskModeHash) {
+		fail("mask_mode: mode inconnu %q", c.MaskMode)
+	}
+	maskRules := append([]MaskRule{}, c.MaskRules...)
+	for _, specs := range [][]string{c.MaskHeaders, c.MaskQueryParams, c.MaskCookies} {
+		for _, spec := range specs {
+			maskRules = append(maskRules, parseMaskRule(spec))
+		}
+	}
+	if _, err := newHeaderMasker(maskRules, c.MaskMode, c.MaskHashKey); err != nil {
+		fail("règles de masquage: %v", err)
+	}
+	for i, rule := range c.BodyRedactRules {
+		if len(newBodyRedactor([]BodyRedactRule{rule}).rules) == 0 {
+			fail("body_redact_rules[%d]: aucun champ valide", i)
+		}
+	}
+	if _, err := newPIIScanner(c.PIIDetectors); err != nil {
+		fail("pii_detectors: %v", err)
+	}
+
+	// Capture des corps
+	if c.MaxRequestBody < -1 {
+		fail("max_request_body: -1 (sans limite) ou taille positive attendue (%d)", c.MaxRequestBody)
+	}
+	if c.MaxResponseBody < -1 {
+		fail("max_response_body: -1 (sans limite) ou taille positive attendue (%d)", c.MaxResponseBody)
+	}
+	if c.MaxDecompressedBody <= 0 {
+		fail("max_decompressed_body: doit être strictement positif (%d)", c.MaxDecompressedBody)
+	}
+	if c.MaxDecompressionRatio < 0 {
+		fail("max_decompression_ratio: doit être positif ou nul (%d)", c.MaxDecompressionRatio)
+	}
+
+	// Ports d'écoute
+	validatePort := func(name string, port int) {
+		if port < 1 || port > 65535 {
+			fail("%s: port invalide %d", name, port)
+		}
+	}
+	validatePort("proxy_port", c.ProxyPort)
+	if c.WebInterface {
+		validatePort("web_port", c.WebPort)
+		if c.WebPort == c.ProxyPort {
+			fail("web_port: identique à proxy_port (%d)", c.WebPort)
+		}
+	}
+	if c.MetricsPort != 0 {
+		validatePort("metrics_port", c.MetricsPort)
+		if c.MetricsPort == c.ProxyPort || (c.WebInterface && c.MetricsPort == c.WebPort) {
+			fail("metrics_port: déjà utilisé par un autre service (%d)", c.MetricsPort)
+		}
+	}
+	if c.FlowTTL <= 0 {
+		fail("flow_ttl: doit être strictement positif (%s)", c.FlowTTL)
+	}
+	if c.ConfigWatchInterval < 0 {
+		fail("config_watch_interval: doit être positif ou nul (%s)", c.ConfigWatchInterval)
+	}
+	if c.CertExpiryWarnDays < 0 {
+		fail("cert_expiry_warn_days: doit être positif ou nul (%d)", c.CertExpiryWarnDays)
+	}
+
+	// Spool disque
+	if !oneOf(c.SpoolFsync, SpoolFsyncAlways, SpoolFsyncInterval, SpoolFsyncNever) {
+		fail("spool_fsync: valeur inconnue %q", c.SpoolFsync)
+	}
+	if c.SpoolDir != "" {
+		if c.SpoolSegmentBytes <= 0 || c.SpoolMaxBytes <= 0 {
+			fail("spool_segment_bytes et spool_max_bytes doivent être strictement positifs")
+		}
+		if c.SpoolDrainInterval <= 0 {
+			fail("spool_drain_interval: doit être strictement positif (%s)", c.SpoolDrainInterval)
+		}
+	}
+
+	// Envoi par lots
+	if !oneOf(c.BatchFormat, BatchFormatJSON, BatchFormatNDJSON) {
+		fail("batch_format: format inconnu %q", c.BatchFormat)
+	}
+	if c.BatchEnabled && (c.BatchMaxCount <= 0 || c.BatchMaxBytes <= 0 || c.BatchMaxLatency <= 0) {
+		fail("batch_max_count, batch_max_bytes et batch_max_latency doivent être strictement positifs")
+	}
+
+	// File et sorties
+	if c.LogWorkers <= 0 {
+		fail("log_workers: doit être strictement positif (%d)", c.LogWorkers)
+	}
+	if c.LogQueueSize <= 0 {
+		fail("log_queue_size: doit être strictement positif (%d)", c.LogQueueSize)
+	}
+	if !oneOf(c.LogQueueOverflow, OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowSpill) {
+		fail("log_queue_overflow: politique inconnue %q", c.LogQueueOverflow)
+	}
+	if c.LogQueueOverflow == OverflowSpill && c.SpoolDir == "" {
+		fail("log_queue_overflow: la politique %q nécessite spool_dir", OverflowSpill)
+	}
+	if len(c.Sinks) == 0 {
+		fail("log_sinks: au moins une sortie est requise")
+	}
+	for _, sink := range c.Sinks {
+		if !oneOf(strings.TrimSpace(sink), SinkLogger, SinkFile, SinkStdout) {
+			fail("log_sinks: sortie inconnue %q", sink)
+		}
+	}
+	if sinkEnabled(c.Sinks, SinkFile) {
+		if c.LogFilePath == "" {
+			fail("log_file_path: requis pour la sortie %q", SinkFile)
+		}
+		if c.LogFileMaxBytes <= 0 {
+			fail("log_file_max_bytes: doit être strictement positif (%d)", c.LogFileMaxBytes)
+		}
+		if c.LogFileMaxBackups < 0 {
+			fail("log_file_max_backups: doit être positif ou nul (%d)", c.LogFileMaxBackups)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateEndpoint - Vérifier qu'une URL de service est absolue en http(s)
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("URL http(s) absolue attendue: %q", endpoint)
+	}
+	return nil
+}
+
+// redactedConfig - Copie de la configuration sans ses secrets, pour affichage
+func redactedConfig(c Config) Config {
+	if c.MaskHashKey != "" {
+		c.MaskHashKey = maskedValue
+	}
+	if c.JWTSecret != "" {
+		c.JWTSecret = maskedValue
+	}
+	if len(c.ProxyAuthTokens) > 0 {
+		tokens := make([]string, len(c.ProxyAuthTokens))
+		for i, spec := range c.ProxyAuthTokens {
+			client, _, _ := strings.Cut(spec, ":")
+			tokens[i] = client + ":" + maskedValue
+		}
+		c.ProxyAuthTokens = tokens
+	}
+	c.LoggerEndpoint = redactURL(c.LoggerEndpoint)
+	c.BatchEndpoint = redactURL(c.BatchEndpoint)
+	return c
+}
+
+// redactURL - Masquer le mot de passe éventuel d'une URL
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// marshalConfig - Sérialiser la configuration effective en YAML, secrets masqués
+func marshalConfig(c Config) ([]byte, error) {
+	return yaml.Marshal(redactedConfig(c))
+}
+
+// splitList - Découper une liste séparée par des virgules en ignorant les éléments vides
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func sinkEnabled(sinks []string, name string) bool {
+	for _, sink := range sinks {
+		if strings.TrimSpace(sink) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// Événements de connexion journalisés (action "connection")
+const (
+	ConnEventClientConnected    = "client_connected"
+	ConnEventClientDisconnected = "client_disconnected"
+	ConnEventServerConnected    = "server_connected"
+	ConnEventServerDisconnected = "server_disconnected"
+)
+
+// ConnectionEvent - Événement d'ouverture ou de fermeture d'une connexion
+//
+// Les événements de fermeture portent le bilan de la connexion: durée,
+// volume échangé et nombre de flux transportés. Les volumes sont approchés
+// par la taille des corps de requête et de réponse, en-têtes exclus.
+type ConnectionEvent struct {
+	ID            string    `json:"id"`
+	Event         string    `json:"event"`
+	ConnectionID  string    `json:"connection_id"`
+	OccuredTime   time.Time `json:"occured_time"`
+	ClientAddress string    `json:"client_address,omitempty"`
+	ServerAddress string    `json:"server_address,omitempty"`
+	TLS           bool      `json:"tls,omitempty"`
+	TLSVersion    string    `json:"tls_version,omitempty"`
+	TLSCipher     string    `json:"tls_cipher,omitempty"`
+	SNI           string    `json:"sni,omitempty"`
+	ALPN          string    `json:"alpn,omitempty"`
+	Duration      int64     `json:"duration,omitempty"` // en millisecondes
+	BytesReceived int64     `json:"bytes_received,omitempty"`
+	BytesSent     int64     `json:"bytes_sent,omitempty"`
+	FlowCount     int       `json:"flow_count"`
+	LogType       string    `json:"log_type"`
+}
+
+// connTracker - Bilan des connexions client en cours
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[string]*connStats
+}
+
+// connStats - Compteurs d'une connexion client et de sa connexion serveur
+type connStats struct {
+	clientConnectedAt time.Time
+	serverConnectedAt time.Time
+	flows             int
+	serverFlowsStart  int
+	bytesReceived     int64
+	bytesSent         int64
+	serverBytesStart  [2]int64
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[string]*connStats)}
+}
+
+// lookup - Retourner les compteurs d'une connexion suivie, verrou tenu
+//
+// Seul clientConnected crée les compteurs: les événements tardifs (fermeture
+// serveur ou fin de flux après la déconnexion du client) ne doivent pas les
+// recréer, faute de quoi ils ne seraient jamais supprimés.
+func (t *connTracker) lookup(client *proxy.ClientConn) (*connStats, bool) {
+	stats, ok := t.conns[client.Id.String()]
+	return stats, ok
+}
+
+// AddFlow - Comptabiliser un flux et ses corps sur une connexion
+func (t *connTracker) AddFlow(ctx *proxy.ConnContext, received, sent int) {
+	if ctx == nil || ctx.ClientConn == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats, ok := t.lookup(ctx.ClientConn)
+	if !ok {
+		return
+	}
+	stats.flows++
+	stats.bytesReceived += int64(received)
+	stats.bytesSent += int64(sent)
+}
+
+// clientConnected - Construire l'événement d'ouverture d'une connexion client
+func (t *connTracker) clientConnected(client *proxy.ClientConn) *ConnectionEvent {
+	t.mu.Lock()
+	t.conns[client.Id.String()] = &connStats{clientConnectedAt: time.Now()}
+	t.mu.Unlock()
+
+	return newConnectionEvent(ConnEventClientConnected, client)
+}
+
+// clientDisconnected - Construire le bilan d'une connexion client et l'oublier
+func (t *connTracker) clientDisconnected(client *proxy.ClientConn) *ConnectionEvent {
+	t.mu.Lock()
+	stats, ok := t.lookup(client)
+	delete(t.conns, client.Id.String())
+	t.mu.Unlock()
+
+	event := newConnectionEvent(ConnEventClientDisconnected, client)
+	event.TLS = client.Tls
+	event.ALPN = client.NegotiatedProtocol
+	if ok {
+		event.Duration = time.Since(stats.clientConnectedAt).Milliseconds()
+		event.BytesReceived = stats.bytesReceived
+		event.BytesSent = stats.bytesSent
+		event.FlowCount = stats.flows
+	}
+	return event
+}
+
+// serverConnected - Construire l'événement d'ouverture d'une connexion serveur
+func (t *connTracker) serverConnected(ctx *proxy.ConnContext) *ConnectionEvent {
+	t.mu.Lock()
+	if stats, ok := t.lookup(ctx.ClientConn); ok {
+		stats.serverConnectedAt = time.Now()
+		stats.serverFlowsStart = stats.flows
+		stats.serverBytesStart = [2]int64{stats.bytesReceived, stats.bytesSent}
+	}
+	t.mu.Unlock()
+
+	event := newConnectionEvent(ConnEventServerConnected, ctx.ClientConn)
+	event.ServerAddress = serverAddress(ctx.ServerConn)
+	return event
+}
+
+// serverDisconnected - Construire le bilan d'une connexion serveur
+func (t *connTracker) serverDisconnected(ctx *proxy.ConnContext) *ConnectionEvent {
+	// Après la déconnexion du client, les compteurs ont déjà été transmis et oubliés
+	var connectedAt time.Time
+	var flows int
+	var received, sent int64
+	t.mu.Lock()
+	if stats, ok := t.lookup(ctx.ClientConn); ok {
+		connectedAt = stats.serverConnectedAt
+		flows = stats.flows - stats.serverFlowsStart
+		received = stats.bytesReceived - stats.serverBytesStart[0]
+		sent = stats.bytesSent - stats.serverBytesStart[1]
+	}
+	t.mu.Unlock()
+
+	event := newConnectionEvent(ConnEventServerDisconnected, ctx.ClientConn)
+	event.ServerAddress = serverAddress(ctx.ServerConn)
+	if !connectedAt.IsZero() {
+		event.Duration = time.Since(connectedAt).Milliseconds()
+	}
+	// Vers le serveur, le sens des volumes est inversé par rapport au client
+	event.BytesSent = received
+	event.BytesReceived = sent
+	event.FlowCount = flows
+
+	if ctx.ServerConn != nil {
+		if state := ctx.ServerConn.TlsState(); state != nil {
+			event.TLS = true
+			event.TLSVersion = tls.VersionName(state.Version)
+			event.TLSCipher = tls.CipherSuiteName(state.CipherSuite)
+			event.SNI = state.ServerName
+			event.ALPN = state.NegotiatedProtocol
+		}
+	}
+	return event
+}
+
+// newConnectionEvent - Initialiser un événement pour une connexion client
+func newConnectionEvent(name string, client *proxy.ClientConn) *ConnectionEvent {
+	event := &ConnectionEvent{
+		ID:           uuid.New().String(),
+		Event:        name,
+		ConnectionID: client.Id.String(),
+		OccuredTime:  time.Now(),
+		LogType:      "info",
+	}
+	if client.Conn != nil {
+		event.ClientAddress = clientAddress(client.Conn)
+	}
+	return event
+}
+
+// serverAddress - Adresse du serveur, résolue si la connexion est établie
+func serverAddress(server *proxy.ServerConn) string {
+	if server == nil {
+		return ""
+	}
+	if server.Conn != nil {
+		return server.Conn.RemoteAddr().String()
+	}
+	return server.Address
+}
+
+// queueConnectionEvent - Sérialiser un événement de connexion et le placer dans la file d'envoi
+func (h *MITMHandler) queueConnectionEvent(event *ConnectionEvent) {
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Erreur lors de la sérialisation de l'événement de connexion: %v", err)
+		return
+	}
+	h.queue.Push(logJob{action: "connection", data: jsonData, key: event.ConnectionID})
+}
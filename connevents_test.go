@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// connectionEvents - Événements de connexion reçus par la sortie mémoire
+func (s *memorySink) connectionEvents(t *testing.T) []ConnectionEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []ConnectionEvent
+	for _, rec := range s.records {
+		if rec.Action != "connection" {
+			continue
+		}
+		var event ConnectionEvent
+		assert.NoError(t, json.Unmarshal(rec.Entry, &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+// TestConnectionEventsLifecycle vérifie le bilan des connexions client et serveur
+func TestConnectionEventsLifecycle(t *testing.T) {
+	handler, sink := newTestHandler(Config{LogConnections: true})
+
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	defer proxySide.Close()
+
+	client := &proxy.ClientConn{Conn: proxySide}
+	ctx := &proxy.ConnContext{ClientConn: client}
+
+	handler.ClientConnected(client)
+	ctx.ServerConn = &proxy.ServerConn{Address: "example.com:80"}
+	handler.ServerConnected(ctx)
+
+	for i := 0; i < 2; i++ {
+		f := newTestFlow("POST", "http://example.com/api", []byte("12345"))
+		f.ConnContext = ctx
+		handler.Request(f)
+		f.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header), Body: []byte("abc")}
+		handler.Done(f)
+	}
+
+	handler.ServerDisconnected(ctx)
+	handler.ClientDisconnected(client)
+	handler.Close()
+
+	// Les workers livrent les événements dans un ordre quelconque
+	events := make(map[string]ConnectionEvent)
+	for _, event := range sink.connectionEvents(t) {
+		events[event.Event] = event
+	}
+	assert.Len(t, events, 4)
+	assert.Equal(t, "pipe", events[ConnEventClientConnected].ClientAddress)
+	assert.Equal(t, "example.com:80", events[ConnEventServerConnected].ServerAddress)
+
+	server := events[ConnEventServerDisconnected]
+	assert.Equal(t, 2, server.FlowCount)
+	assert.Equal(t, int64(10), server.BytesSent, "Corps envoyés au serveur")
+	assert.Equal(t, int64(6), server.BytesReceived, "Corps reçus du serveur")
+
+	closed := events[ConnEventClientDisconnected]
+	assert.Equal(t, 2, closed.FlowCount)
+	assert.Equal(t, int64(10), closed.BytesReceived)
+	assert.Equal(t, int64(6), closed.BytesSent)
+	assert.Empty(t, handler.conns.conns, "La connexion fermée doit être oubliée")
+}
+
+// TestConnectionEventsDisabled vérifie qu'aucun événement n'est émis sans l'option
+func TestConnectionEventsDisabled(t *testing.T) {
+	handler, sink := newTestHandler(Config{})
+
+	client := &proxy.ClientConn{}
+	handler.ClientConnected(client)
+	handler.ClientDisconnected(client)
+	handler.Close()
+
+	assert.Empty(t, sink.connectionEvents(t))
+}
+
+// TestConnectionEventsLateCallbacksDoNotLeak vérifie que les événements après la déconnexion du client ne recréent pas de compteurs
+func TestConnectionEventsLateCallbacksDoNotLeak(t *testing.T) {
+	handler, sink := newTestHandler(Config{LogConnections: true})
+
+	client := &proxy.ClientConn{}
+	ctx := &proxy.ConnContext{ClientConn: client, ServerConn: &proxy.ServerConn{Address: "example.com:80"}}
+	handler.ClientConnected(client)
+	handler.ServerConnected(ctx)
+
+	// go-mitmproxy ferme la connexion serveur après avoir signalé la déconnexion du client
+	f := newTestFlow("GET", "http://example.com/api", nil)
+	f.ConnContext = ctx
+	handler.Request(f)
+	handler.ClientDisconnected(client)
+	handler.ServerDisconnected(ctx)
+	f.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header)}
+	handler.Done(f)
+	handler.Close()
+
+	assert.Empty(t, handler.conns.conns, "Aucun compteur ne doit subsister après la déconnexion du client")
+	for _, rec := range sink.records {
+		if rec.Action == "connection" {
+			assert.Contains(t, string(rec.Entry), `"flow_count":`, "Le nombre de flux doit être transmis, même nul")
+		}
+	}
+	assert.Len(t, sink.connectionEvents(t), 4)
+}
+
+// TestConnectionEventsBypassBulkEndpoint vérifie qu'en mode lot les événements de connexion gardent leur point de terminaison
+func TestConnectionEventsBypassBulkEndpoint(t *testing.T) {
+	var mu sync.Mutex
+	paths := make(map[string][]string)
+	loggerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		paths[r.URL.Path] = append(paths[r.URL.Path], string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer loggerServer.Close()
+
+	config := withDefaults(Config{LoggerEndpoint: loggerServer.URL + "/api/logs", BatchEnabled: true})
+	config.BatchEndpoint = config.LoggerEndpoint + "/bulk"
+	sink := newLoggerSink(config)
+
+	assert.NoError(t, sink.Write("connection", []byte(`{"id":"e1","event":"client_connected","connection_id":"c1"}`)))
+	assert.NoError(t, sink.Write("create", []byte(`{"id":"f1"}`)))
+	assert.NoError(t, sink.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{`{"id":"e1","event":"client_connected","connection_id":"c1"}`}, paths["/api/logs/connection"])
+	if assert.Len(t, paths["/api/logs/bulk"], 1) {
+		assert.JSONEq(t, `[{"action":"create","entry":{"id":"f1"}}]`, paths["/api/logs/bulk"][0], "Le lot ne doit contenir que des flux")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// errDecompressionBomb - Le contenu décompressé dépasse les plafonds configurés
+var errDecompressionBomb = errors.New("bombe de décompression suspectée: plafond de taille ou de ratio dépassé")
+
+// decodeContent - Décompresser un corps selon son Content-Encoding
+//
+// Les encodages multiples ("gzip, br") sont défaits dans l'ordre inverse de
+// leur application. La taille décompressée est plafonnée à maxBytes et à
+// maxRatio fois la taille compressée (0 = pas de plafond de ratio).
+func decodeContent(contentEncoding string, body []byte, maxBytes, maxRatio int) ([]byte, error) {
+	limit := maxBytes
+	if maxRatio > 0 && len(body)*maxRatio < limit {
+		limit = len(body) * maxRatio
+	}
+
+	encodings := strings.Split(contentEncoding, ",")
+	data := body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+
+		reader, err := newContentDecoder(encoding, data)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+		if closer, ok := reader.(io.Closer); ok {
+			closer.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("décompression %s: %w", encoding, err)
+		}
+		if len(decoded) > limit {
+			return nil, errDecompressionBomb
+		}
+		data = decoded
+	}
+	return data, nil
+}
+
+// newContentDecoder - Créer le lecteur de décompression d'un encodage
+func newContentDecoder(encoding string, data []byte) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(bytes.NewReader(data))
+	case "br":
+		return brotli.NewReader(bytes.NewReader(data)), nil
+	case "deflate":
+		// "deflate" désigne normalement du zlib, mais certains serveurs envoient du deflate brut
+		if reader, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+			return reader, nil
+		}
+		return flate.NewReader(bytes.NewReader(data)), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("encodage de contenu non supporté: %q", encoding)
+	}
+}
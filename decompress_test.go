@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func brotliBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := brotli.NewWriter(&buf)
+	_, err := w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+// TestDecodeContentStackedEncodings vérifie que les encodages multiples sont défaits dans l'ordre inverse
+func TestDecodeContentStackedEncodings(t *testing.T) {
+	plain := []byte(`{"message":"bonjour"}`)
+	encoded := brotliBytes(t, gzipBytes(t, plain))
+
+	decoded, err := decodeContent("gzip, br", encoded, 1024, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, decoded)
+
+	_, err = decodeContent("compress", encoded, 1024, 0)
+	assert.Error(t, err, "Un encodage inconnu doit être signalé")
+}
+
+// TestDecodeContentRejectsBombs vérifie les plafonds de taille et de ratio
+func TestDecodeContentRejectsBombs(t *testing.T) {
+	bomb := gzipBytes(t, bytes.Repeat([]byte("a"), 1024*1024))
+
+	_, err := decodeContent("gzip", bomb, 10*1024*1024, 100)
+	assert.ErrorIs(t, err, errDecompressionBomb, "Le ratio de compression doit être plafonné")
+
+	_, err = decodeContent("gzip", bomb, 1024, 0)
+	assert.ErrorIs(t, err, errDecompressionBomb, "La taille décompressée doit être plafonnée")
+}
+
+// TestResponseBodyIsDecodedForLogging vérifie que le journal reçoit le corps décompressé sans toucher à la réponse
+func TestResponseBodyIsDecodedForLogging(t *testing.T) {
+	handler, sink := newTestHandler(Config{DecodeBodies: true})
+
+	plain := `{"message":"` + strings.Repeat("bonjour ", 20) + `"}`
+	compressed := gzipBytes(t, []byte(plain))
+
+	f := newTestFlow("GET", "http://example.com/api/resource", nil)
+	handler.Request(f)
+	f.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header), Body: compressed}
+	f.Response.Header.Set("Content-Type", "application/json")
+	f.Response.Header.Set("Content-Encoding", "gzip")
+	handler.Response(f)
+	handler.Close()
+
+	assert.Equal(t, compressed, f.Response.Body, "La réponse transmise au client ne doit pas être modifiée")
+
+	updates := sink.entries(t, "update")
+	assert.Len(t, updates, 1)
+	assert.Equal(t, plain, updates[0].HTTPReturnBody)
+	assert.Equal(t, "gzip", updates[0].HTTPReturnContentEncoding)
+	assert.Equal(t, len(compressed), updates[0].HTTPReturnCompressedSize)
+	assert.Equal(t, len(plain), updates[0].HTTPReturnBodySize)
+	assert.Empty(t, updates[0].HTTPReturnDecodeError)
+}
+
+// TestResponseBodyDecodeErrorIsLogged vérifie qu'un corps corrompu est journalisé tel quel avec l'erreur
+func TestResponseBodyDecodeErrorIsLogged(t *testing.T) {
+	handler, sink := newTestHandler(Config{DecodeBodies: true})
+
+	f := newTestFlow("GET", "http://example.com/api/resource", nil)
+	handler.Request(f)
+	f.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header), Body: []byte{0x1f, 0x8b, 0x00}}
+	f.Response.Header.Set("Content-Encoding", "gzip")
+	handler.Response(f)
+	handler.Close()
+
+	updates := sink.entries(t, "update")
+	assert.Len(t, updates, 1)
+	assert.NotEmpty(t, updates[0].HTTPReturnDecodeError, "L'erreur de décompression doit être journalisée")
+	assert.Equal(t, BodyEncodingBase64, updates[0].HTTPReturnBodyEncoding, "Le corps compressé doit être conservé en base64")
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// flowState - Entrée de journal en attente de la réponse du serveur
+type flowState struct {
+	entry      *LogModel
+	rules      *ruleSet // règles en vigueur au début du flux
+	expires    time.Time
+	responding bool // en-têtes de réponse reçus: le flux n'expire plus
+}
+
+// flowStore - Stockage des flux en cours, sûr pour un accès concurrent
+//
+// Les flux qui ne reçoivent jamais de réponse (client qui abandonne, serveur
+// bloqué) sont évincés après le TTL et transmis à onEvict. Un flux dont les
+// en-têtes de réponse sont arrivés n'est plus évincé: un téléchargement long
+// ou en flux continu se termine par Response, Error ou Done.
+type flowStore struct {
+	mu      sync.Mutex
+	entries map[string]*flowState
+	ttl     time.Duration
+	onEvict func(*LogModel)
+	stop    chan struct{}
+}
+
+// newFlowStore - Créer un stockage de flux et démarrer l'éviction périodique
+func newFlowStore(ttl time.Duration, onEvict func(*LogModel)) *flowStore {
+	s := &flowStore{
+		entries: make(map[string]*flowState),
+		ttl:     ttl,
+		onEvict: onEvict,
+		stop:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Put - Enregistrer l'entrée de journal d'un flux et les règles qui s'appliquent au flux
+func (s *flowStore) Put(id string, entry *LogModel, rules *ruleSet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[id] = &flowState{entry: entry, rules: rules, expires: time.Now().Add(s.ttl)}
+}
+
+// Take - Retirer et retourner l'entrée de journal d'un flux et ses règles
+//
+// Seul l'appelant qui obtient l'entrée peut la modifier ensuite.
+func (s *flowStore) Take(id string) (*LogModel, *ruleSet, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.entries[id]
+	if !ok {
+		return nil, nil, false
+	}
+	delete(s.entries, id)
+	return state.entry, state.rules, true
+}
+
+// Responding - Soustraire à l'éviction un flux dont la réponse a commencé
+func (s *flowStore) Responding(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state, ok := s.entries[id]; ok {
+		state.responding = true
+	}
+}
+
+// Len - Nombre de flux en attente de réponse
+func (s *flowStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+// Close - Arrêter l'éviction périodique
+func (s *flowStore) Close() {
+	close(s.stop)
+}
+
+// run - Évincer périodiquement les flux expirés
+func (s *flowStore) run() {
+	interval := s.ttl / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			for _, entry := range s.evictExpired(now) {
+				if s.onEvict != nil {
+					s.onEvict(entry)
+				}
+			}
+		}
+	}
+}
+
+// evictExpired - Retirer les flux expirés à l'instant donné
+func (s *flowStore) evictExpired(now time.Time) []*LogModel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []*LogModel
+	for id, state := range s.entries {
+		if !state.responding && now.After(state.expires) {
+			expired = append(expired, state.entry)
+			delete(s.entries, id)
+		}
+	}
+	return expired
+}
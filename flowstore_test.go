@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFlowStoreConcurrentAccess vérifie que le stockage supporte des accès concurrents
+func TestFlowStoreConcurrentAccess(t *testing.T) {
+	store := newFlowStore(time.Minute, nil)
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("flow-%d", i)
+			store.Put(id, &LogModel{ID: id}, nil)
+			entry, _, ok := store.Take(id)
+			assert.True(t, ok, "L'entrée doit être retrouvée")
+			assert.Equal(t, id, entry.ID)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 0, store.Len(), "Le stockage doit être vide")
+}
+
+// TestFlowStoreEvictsExpiredFlows vérifie que les flux sans réponse sont évincés après le TTL
+func TestFlowStoreEvictsExpiredFlows(t *testing.T) {
+	evicted := make(chan *LogModel, 1)
+	store := newFlowStore(20*time.Millisecond, func(entry *LogModel) { evicted <- entry })
+	defer store.Close()
+
+	store.Put("flow-1", &LogModel{ID: "flow-1"}, nil)
+	assert.Equal(t, 1, store.Len())
+
+	select {
+	case entry := <-evicted:
+		assert.Equal(t, "flow-1", entry.ID, "Le flux expiré doit être transmis à onEvict")
+	case <-time.After(time.Second):
+		t.Fatal("Le flux expiré n'a pas été évincé")
+	}
+	assert.Equal(t, 0, store.Len(), "Le flux évincé doit être retiré du stockage")
+
+	_, _, ok := store.Take("flow-1")
+	assert.False(t, ok, "Un flux évincé ne doit plus être disponible pour Response")
+}
+
+// TestSlowResponseBodyIsNotEvicted vérifie qu'une réponse dont le corps dépasse le TTL garde son vrai code
+func TestSlowResponseBodyIsNotEvicted(t *testing.T) {
+	handler, sink := newTestHandler(Config{FlowTTL: 20 * time.Millisecond})
+
+	f := newTestFlow("GET", "http://example.com/files/large.iso", nil)
+	handler.Request(f)
+	f.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header)}
+	handler.Responseheaders(f)
+
+	// Corps reçu bien après le TTL
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, handler.flows.Len(), "Un flux dont la réponse a commencé ne doit pas être évincé")
+	f.Response.Body = []byte("contenu")
+	handler.Response(f)
+	handler.Close()
+
+	updates := sink.entries(t, "update")
+	if assert.Len(t, updates, 1, "Seule la fin réelle du flux doit être journalisée") {
+		assert.Equal(t, 200, updates[0].HTTPReturnCode)
+	}
+}
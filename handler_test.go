@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// memorySink - Sortie de test conservant les entrées reçues en mémoire
+type memorySink struct {
+	mu      sync.Mutex
+	records []SpoolRecord
+}
+
+func (s *memorySink) Name() string { return "memory" }
+func (s *memorySink) Close() error { return nil }
+
+func (s *memorySink) Write(action string, entry []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, SpoolRecord{Action: action, Entry: entry})
+	return nil
+}
+
+// entries - Retourner les entrées reçues pour une action donnée
+func (s *memorySink) entries(t *testing.T, action string) []LogModel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []LogModel
+	for _, rec := range s.records {
+		if rec.Action != action {
+			continue
+		}
+		var entry LogModel
+		assert.NoError(t, json.Unmarshal(rec.Entry, &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// newTestHandler - Créer un gestionnaire dont les journaux sont capturés en mémoire
+//
+// Les champs laissés à zéro prennent leur valeur de defaultConfig, comme
+// pour une configuration chargée sans les renseigner.
+func newTestHandler(config Config) (*MITMHandler, *memorySink) {
+	config = withDefaults(config)
+	config.Sinks = []string{"memory"}
+	handler := NewMITMHandler(config)
+	sink := &memorySink{}
+	handler.sinks = []Sink{sink}
+	return handler, sink
+}
+
+// withDefaults - Compléter les champs nuls d'une configuration de test par les valeurs par défaut
+func withDefaults(config Config) Config {
+	merged := defaultConfig()
+	src := reflect.ValueOf(config)
+	dst := reflect.ValueOf(&merged).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if !src.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	if merged.LogQueueOverflow == "" {
+		merged.LogQueueOverflow = defaultOverflowPolicy(merged.SpoolDir)
+	}
+	return merged
+}
+
+// newTestFlow - Créer un flux de test pour la méthode et l'URL données
+func newTestFlow(method, rawURL string, body []byte) *proxy.Flow {
+	u, _ := url.Parse(rawURL)
+	return &proxy.Flow{
+		Request: &proxy.Request{
+			Method: method,
+			URL:    u,
+			Proto:  "HTTP/1.1",
+			Header: make(http.Header),
+			Body:   body,
+		},
+	}
+}
+
+// TestResponseHeadersAreLogged vérifie que les en-têtes de réponse sont ajoutés au journal
+func TestResponseHeadersAreLogged(t *testing.T) {
+	handler, sink := newTestHandler(Config{})
+
+	f := newTestFlow("GET", "http://example.com/api/resource", nil)
+	handler.Request(f)
+	f.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header), Body: []byte(`{}`)}
+	f.Response.Header.Set("Content-Type", "application/json")
+	f.Response.Header.Set("Cache-Control", "no-cache")
+	handler.Response(f)
+	handler.Close()
+
+	updates := sink.entries(t, "update")
+	assert.Len(t, updates, 1)
+	assert.Equal(t, "application/json", updates[0].HTTPResponseHeaders["Content-Type"], "Les en-têtes de réponse doivent être journalisés")
+	assert.Equal(t, "no-cache", updates[0].HTTPResponseHeaders["Cache-Control"])
+}
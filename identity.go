@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// Sources d'une chaîne d'extraction de l'identité ("type:nom")
+const (
+	IdentityHeader = "header" // en-tête de la requête
+	IdentityQuery  = "query"  // paramètre de l'URL
+	IdentityCookie = "cookie" // cookie de la requête
+	IdentityJWT    = "jwt"    // claim du jeton "Authorization: Bearer"
+	IdentityStatic = "static" // valeur fixe, en fin de chaîne
+
+	IdentityVerifiedJWT = "verified_jwt" // claim du jeton, seulement si sa signature est vérifiée
+)
+
+// Chaînes d'extraction par défaut
+//
+// Les claims d'un jeton vérifié passent avant les en-têtes, que le client
+// peut fixer librement; ceux d'un jeton non vérifié passent après.
+var (
+	defaultIdentityClient = []string{"verified_jwt:azp", "verified_jwt:client_id",
+		"header:client-name", "jwt:azp", "jwt:client_id", "static:Anonyme"}
+	defaultIdentityUser = []string{"verified_jwt:preferred_username", "verified_jwt:sub",
+		"header:username", "header:user", "jwt:preferred_username", "jwt:sub", "static:Anonyme"}
+	defaultIdentityCorrelation = []string{"header:correlation-id"}
+	defaultIdentityTenant      = []string{"jwt:tid", "jwt:tenant"}
+)
+
+// identitySource - Source d'une chaîne d'extraction
+type identitySource struct {
+	kind string
+	name string
+}
+
+// identityChain - Sources essayées dans l'ordre, la première valeur non vide l'emporte
+type identityChain []identitySource
+
+// parseIdentityChain - Lire une chaîne au format ["header:X-User", "jwt:sub", "static:Anonyme"]
+//
+// Les sources invalides sont ignorées et signalées dans l'erreur retournée.
+func parseIdentityChain(specs []string) (identityChain, error) {
+	chain := make(identityChain, 0, len(specs))
+	var errs []error
+	for _, spec := range specs {
+		kind, name, ok := strings.Cut(strings.TrimSpace(spec), ":")
+		kind = strings.ToLower(kind)
+		if !ok || (name == "" && kind != IdentityStatic) {
+			errs = append(errs, fmt.Errorf("source d'identité invalide %q: \"type:nom\" attendu", spec))
+			continue
+		}
+		switch kind {
+		case IdentityHeader, IdentityQuery, IdentityCookie, IdentityJWT, IdentityVerifiedJWT, IdentityStatic:
+		case "cert":
+			// Hors périmètre: go-mitmproxy ne demande jamais de certificat aux clients,
+			// la source serait toujours vide
+			errs = append(errs, fmt.Errorf("source d'identité %q indisponible: le proxy ne demande pas de certificat client", spec))
+			continue
+		default:
+			errs = append(errs, fmt.Errorf("type de source d'identité inconnu %q", kind))
+			continue
+		}
+		chain = append(chain, identitySource{kind: kind, name: name})
+	}
+	return chain, errors.Join(errs...)
+}
+
+// usesJWT - Indiquer si la chaîne lit le jeton Bearer
+func (c identityChain) usesJWT() bool {
+	for _, source := range c {
+		if source.kind == IdentityJWT || source.kind == IdentityVerifiedJWT {
+			return true
+		}
+	}
+	return false
+}
+
+// correlationHeader - En-tête portant l'ID de corrélation: la première source "header:" de sa chaîne
+func (e *identityExtractor) correlationHeader() string {
+	for _, source := range e.correlation {
+		if source.kind == IdentityHeader {
+			return source.name
+		}
+	}
+	return "correlation-id"
+}
+
+// requestIdentity - Identité extraite d'une requête
+type requestIdentity struct {
+	Client        string
+	User          string
+	CorrelationID string
+	Tenant        string
+	JWTVerified   *bool // renseigné si un jeton Bearer a été décodé
+}
+
+// identityExtractor - Chaînes d'extraction des champs d'identité du journal
+type identityExtractor struct {
+	client      identityChain
+	user        identityChain
+	correlation identityChain
+	tenant      identityChain
+	jwt         *jwtDecoder // nil si aucune chaîne ne lit le jeton
+}
+
+// newIdentityExtractor - Compiler les chaînes d'extraction de la configuration
+//
+// L'extracteur retourné est toujours utilisable: les sources invalides et
+// les clés JWKS illisibles sont ignorées et signalées dans l'erreur.
+func newIdentityExtractor(c Config) (*identityExtractor, error) {
+	e := &identityExtractor{}
+	var errs []error
+	fields := []struct {
+		name  string
+		specs []string
+		dst   *identityChain
+	}{
+		{"identity_client", c.IdentityClient, &e.client},
+		{"identity_user", c.IdentityUser, &e.user},
+		{"identity_correlation", c.IdentityCorrelation, &e.correlation},
+		{"identity_tenant", c.IdentityTenant, &e.tenant},
+	}
+	for _, field := range fields {
+		chain, err := parseIdentityChain(field.specs)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field.name, err))
+		}
+		*field.dst = chain
+	}
+
+	if e.client.usesJWT() || e.user.usesJWT() || e.correlation.usesJWT() || e.tenant.usesJWT() {
+		decoder, err := newJWTDecoder(c)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		e.jwt = decoder
+	}
+	return e, errors.Join(errs...)
+}
+
+// extract - Extraire l'identité d'une requête
+func (e *identityExtractor) extract(req *proxy.Request) requestIdentity {
+	var token *bearerToken
+	var identity requestIdentity
+	if e.jwt != nil {
+		if decoded, ok := e.jwt.decode(req.Header); ok {
+			token = decoded
+			identity.JWTVerified = &decoded.verified
+		}
+	}
+
+	identity.Client = e.client.resolve(req, token)
+	identity.User = e.user.resolve(req, token)
+	identity.CorrelationID = e.correlation.resolve(req, token)
+	identity.Tenant = e.tenant.resolve(req, token)
+	return identity
+}
+
+// resolve - Première valeur non vide fournie par les sources de la chaîne
+func (c identityChain) resolve(req *proxy.Request, token *bearerToken) string {
+	for _, source := range c {
+		var value string
+		switch source.kind {
+		case IdentityHeader:
+			value = req.Header.Get(source.name)
+		case IdentityQuery:
+			if req.URL != nil {
+				value = req.URL.Query().Get(source.name)
+			}
+		case IdentityCookie:
+			value = cookieValue(req, source.name)
+		case IdentityJWT:
+			if token != nil {
+				value = token.claim(source.name)
+			}
+		case IdentityVerifiedJWT:
+			if token != nil && token.verified {
+				value = token.claim(source.name)
+			}
+		case IdentityStatic:
+			value = source.name
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// cookieValue - Valeur d'un cookie de la requête
+func cookieValue(req *proxy.Request, name string) string {
+	for _, line := range req.Header.Values("Cookie") {
+		for _, pair := range strings.Split(line, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if key == name {
+				return strings.Trim(value, `"`)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIdentityChainsCustomMapping vérifie une correspondance propre à une équipe
+func TestIdentityChainsCustomMapping(t *testing.T) {
+	extractor, err := newIdentityExtractor(Config{
+		IdentityClient:      []string{"header:X-Client-Id", "static:inconnu"},
+		IdentityUser:        []string{"header:X-User", "query:user", "cookie:uid", "jwt:sub"},
+		IdentityCorrelation: []string{"header:X-Request-Id", "query:rid"},
+		IdentityTenant:      []string{"static:"},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, extractor.jwt, "Le jeton doit être décodé pour la source jwt:sub")
+
+	req := newTestFlow("GET", "http://example.com/api?user=carol&rid=abc", nil).Request
+	req.Header.Set("X-Client-Id", "facturation")
+	req.Header.Set("X-Request-Id", "req-1")
+	assert.Equal(t, requestIdentity{Client: "facturation", User: "carol", CorrelationID: "req-1"}, extractor.extract(req))
+
+	req = newTestFlow("GET", "http://example.com/api", nil).Request
+	req.Header.Set("Cookie", "theme=dark; uid=dave")
+	assert.Equal(t, requestIdentity{Client: "inconnu", User: "dave"}, extractor.extract(req),
+		"Les sources suivantes et la valeur fixe doivent servir de repli")
+}
+
+// TestIdentityChainsDefaults vérifie que les chaînes par défaut reprennent les en-têtes historiques et le jeton
+func TestIdentityChainsDefaults(t *testing.T) {
+	extractor, err := newIdentityExtractor(Config{
+		IdentityClient:      defaultIdentityClient,
+		IdentityUser:        defaultIdentityUser,
+		IdentityCorrelation: defaultIdentityCorrelation,
+		IdentityTenant:      defaultIdentityTenant,
+	})
+	assert.NoError(t, err)
+
+	req := newTestFlow("GET", "http://example.com/", nil).Request
+	req.Header.Set("client-name", "portail")
+	req.Header.Set("user", "erin")
+	req.Header.Set("correlation-id", "corr-1")
+	assert.Equal(t, requestIdentity{Client: "portail", User: "erin", CorrelationID: "corr-1"}, extractor.extract(req))
+
+	token := signJWT(t, map[string]interface{}{"alg": "HS256"},
+		map[string]interface{}{"sub": "42", "client_id": "batch", "tenant": "acme"}, hs256("cle"))
+	req = newTestFlow("GET", "http://example.com/", nil).Request
+	req.Header = bearerHeader(token)
+	identity := extractor.extract(req)
+	assert.Equal(t, "batch", identity.Client)
+	assert.Equal(t, "42", identity.User)
+	assert.Equal(t, "acme", identity.Tenant)
+	if assert.NotNil(t, identity.JWTVerified) {
+		assert.False(t, *identity.JWTVerified, "Sans clé configurée, le jeton ne peut être vérifié")
+	}
+
+	assert.Equal(t, requestIdentity{Client: "Anonyme", User: "Anonyme"}, extractor.extract(newTestFlow("GET", "http://example.com/", nil).Request))
+}
+
+// TestIdentityChainsInvalidSources vérifie le signalement des sources invalides sans bloquer les autres
+func TestIdentityChainsInvalidSources(t *testing.T) {
+	for _, spec := range []string{"X-User", "ldap:uid", "header:", "cert:subject.email"} {
+		_, err := parseIdentityChain([]string{spec})
+		assert.Error(t, err, "La source %q doit être refusée", spec)
+	}
+
+	chain, err := parseIdentityChain([]string{"ldap:uid", "Header:X-User", "static:Anonyme"})
+	assert.Error(t, err)
+	assert.Equal(t, identityChain{{kind: IdentityHeader, name: "X-User"}, {kind: IdentityStatic, name: "Anonyme"}}, chain)
+
+	_, err = loadConfig(writeConfigFile(t, "config.yaml", "identity_user: [\"ldap:uid\"]\n"))
+	assert.Error(t, err, "Le chargement doit échouer sur une source inconnue")
+	_, err = loadConfig(writeConfigFile(t, "config.yaml", "identity_client: [\"cert:subject.cn\"]\n"))
+	assert.Error(t, err, "Le certificat client n'étant jamais demandé, la source cert doit être refusée")
+}
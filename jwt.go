@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // SHA-256 pour crypto.Hash.New
+	_ "crypto/sha512" // SHA-384 et SHA-512 pour crypto.Hash.New
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jwtLeeway - Tolérance de décalage d'horloge pour les claims "exp" et "nbf"
+const jwtLeeway = time.Minute
+
+// jwtDecoder - Décodage des jetons "Authorization: Bearer"
+//
+// Le jeton est toujours décodé; sa signature n'est vérifiée que si une clé
+// partagée (HS256/384/512) ou un fichier JWKS (RS*, PS*, ES*) est configuré.
+// Un jeton expiré ou pas encore valide ("exp", "nbf") est ignoré.
+// Le jeton lui-même n'est jamais journalisé: seul l'en-tête masqué l'est.
+type jwtDecoder struct {
+	secret []byte
+	keys   []jwk
+}
+
+// jwk - Clé publique d'un fichier JWKS
+type jwk struct {
+	kid string
+	key crypto.PublicKey
+}
+
+// bearerToken - Claims d'un jeton décodé
+type bearerToken struct {
+	claims   map[string]interface{}
+	verified bool
+}
+
+// newJWTDecoder - Préparer le décodage et la vérification des jetons
+func newJWTDecoder(c Config) (*jwtDecoder, error) {
+	j := &jwtDecoder{}
+	if c.JWTSecret != "" {
+		j.secret = []byte(c.JWTSecret)
+	}
+	if c.JWTJWKSFile != "" {
+		keys, err := loadJWKS(c.JWTJWKSFile)
+		j.keys = keys
+		if err != nil {
+			return j, fmt.Errorf("fichier JWKS %s: %w", c.JWTJWKSFile, err)
+		}
+	}
+	return j, nil
+}
+
+// decode - Décoder le jeton Bearer d'une requête
+func (j *jwtDecoder) decode(header http.Header) (*bearerToken, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header.Get("Authorization")), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, false
+	}
+
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	var head struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	var claims map[string]interface{}
+	if decodeJWTSegment(parts[0], &head) != nil || decodeJWTSegment(parts[1], &claims) != nil {
+		return nil, false
+	}
+	if !jwtTimeValid(claims, time.Now()) {
+		return nil, false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, false
+	}
+
+	return &bearerToken{
+		claims:   claims,
+		verified: j.verify(head.Alg, head.Kid, []byte(parts[0]+"."+parts[1]), signature),
+	}, true
+}
+
+// decodeJWTSegment - Décoder un segment base64url JSON
+func decodeJWTSegment(segment string, dst interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+// jwtTimeValid - Vérifier les claims "exp" et "nbf" (absents = sans contrainte)
+func jwtTimeValid(claims map[string]interface{}, now time.Time) bool {
+	for _, name := range []string{"exp", "nbf"} {
+		value, ok := claims[name]
+		if !ok {
+			continue
+		}
+		seconds, ok := value.(float64)
+		if !ok {
+			return false
+		}
+		at := time.Unix(int64(seconds), 0)
+		if name == "exp" && !now.Before(at.Add(jwtLeeway)) {
+			return false
+		}
+		if name == "nbf" && now.Add(jwtLeeway).Before(at) {
+			return false
+		}
+	}
+	return true
+}
+
+// claim - Valeur texte ou numérique d'un claim (vide sinon)
+func (t *bearerToken) claim(name string) string {
+	switch value := t.claims[name].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return ""
+}
+
+// verify - Vérifier la signature d'un jeton avec les clés configurées
+func (j *jwtDecoder) verify(alg, kid string, signed, signature []byte) bool {
+	alg = strings.ToUpper(alg)
+	if len(alg) != 5 {
+		return false
+	}
+	family := alg[:2]
+	hash, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return false
+	}
+
+	switch family {
+	case "HS":
+		if j.secret == nil {
+			return false
+		}
+		mac := hmac.New(hash.New, j.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS", "PS", "ES":
+		digest := hash.New()
+		digest.Write(signed)
+		sum := digest.Sum(nil)
+		for _, key := range j.keys {
+			if kid != "" && key.kid != "" && key.kid != kid {
+				continue
+			}
+			if verifyJWTSignature(family, key.key, hash, sum, signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwtHashes - Fonction de hachage par taille d'algorithme
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// verifyJWTSignature - Vérifier une signature asymétrique (famille "RS", "PS" ou "ES")
+func verifyJWTSignature(family string, key crypto.PublicKey, hash crypto.Hash, sum, signature []byte) bool {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if family == "RS" {
+			return rsa.VerifyPKCS1v15(pub, hash, sum, signature) == nil
+		}
+		if family == "PS" {
+			return rsa.VerifyPSS(pub, hash, sum, signature, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		// Signature JWS: r et s concaténés, chacun sur la taille de la courbe
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if family != "ES" || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(pub, sum, r, s)
+	}
+	return false
+}
+
+// loadJWKS - Lire les clés publiques RSA et EC d'un fichier JWKS
+//
+// Les clés d'un autre type (symétriques notamment) sont ignorées.
+func loadJWKS(path string) ([]jwk, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []jwk
+	var errs []error
+	for i, k := range set.Keys {
+		var key crypto.PublicKey
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 {
+				errs = append(errs, fmt.Errorf("clé %d: paramètres RSA invalides", i))
+				continue
+			}
+			key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			curve, ok := jwkCurves[k.Crv]
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if !ok || errX != nil || errY != nil {
+				errs = append(errs, fmt.Errorf("clé %d: paramètres EC invalides", i))
+				continue
+			}
+			key = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		default:
+			continue
+		}
+		keys = append(keys, jwk{kid: k.Kid, key: key})
+	}
+	if len(keys) == 0 {
+		errs = append(errs, errors.New("aucune clé utilisable"))
+	}
+	return keys, errors.Join(errs...)
+}
+
+// jwkCurves - Courbes elliptiques des clés EC
+var jwkCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
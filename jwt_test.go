@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// signJWT - Construire un jeton signé par sign à partir de son en-tête et de ses claims
+func signJWT(t *testing.T, head, claims map[string]interface{}, sign func(signed []byte) []byte) string {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		assert.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(head) + "." + encode(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+// hs256 - Signature HMAC-SHA256 avec la clé donnée
+func hs256(secret string) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+// bearerHeader - En-têtes d'une requête portant le jeton donné
+func bearerHeader(token string) http.Header {
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+token)
+	return header
+}
+
+// TestJWTDecoderSharedSecret vérifie la lecture des claims et la vérification HS256
+func TestJWTDecoderSharedSecret(t *testing.T) {
+	decoder, err := newJWTDecoder(Config{JWTSecret: "secret-partage"})
+	assert.NoError(t, err)
+
+	claims := map[string]interface{}{"sub": "42", "azp": "portail", "exp": 4102444800}
+	token := signJWT(t, map[string]interface{}{"alg": "HS256"}, claims, hs256("secret-partage"))
+	got, ok := decoder.decode(bearerHeader(token))
+	assert.True(t, ok)
+	assert.True(t, got.verified)
+	assert.Equal(t, "portail", got.claim("azp"))
+	assert.Equal(t, "4102444800", got.claim("exp"), "Les claims numériques doivent être lisibles")
+	assert.Empty(t, got.claim("tid"))
+
+	forged := signJWT(t, map[string]interface{}{"alg": "HS256"}, claims, hs256("autre-secret"))
+	got, ok = decoder.decode(bearerHeader(forged))
+	assert.True(t, ok, "Un jeton non vérifié doit rester lisible")
+	assert.False(t, got.verified, "Une signature invalide ne doit pas être vérifiée")
+	assert.Equal(t, "42", got.claim("sub"))
+
+	_, ok = decoder.decode(bearerHeader("pas-un-jwt"))
+	assert.False(t, ok)
+	header := make(http.Header)
+	header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	_, ok = decoder.decode(header)
+	assert.False(t, ok, "Seuls les jetons Bearer doivent être lus")
+}
+
+// TestJWTDecoderTimeClaims vérifie qu'un jeton expiré ou pas encore valide est ignoré
+func TestJWTDecoderTimeClaims(t *testing.T) {
+	decoder, err := newJWTDecoder(Config{JWTSecret: "secret-partage"})
+	assert.NoError(t, err)
+	now := time.Now().Unix()
+
+	cases := map[string]struct {
+		claims map[string]interface{}
+		valid  bool
+	}{
+		"expiré":                  {map[string]interface{}{"sub": "42", "exp": now - 3600}, false},
+		"pas encore valide":       {map[string]interface{}{"sub": "42", "nbf": now + 3600}, false},
+		"dans la tolérance":       {map[string]interface{}{"sub": "42", "exp": now - 10, "nbf": now + 10}, true},
+		"claim exp non numérique": {map[string]interface{}{"sub": "42", "exp": "demain"}, false},
+		"sans contrainte":         {map[string]interface{}{"sub": "42"}, true},
+	}
+	for name, c := range cases {
+		token := signJWT(t, map[string]interface{}{"alg": "HS256"}, c.claims, hs256("secret-partage"))
+		_, ok := decoder.decode(bearerHeader(token))
+		assert.Equal(t, c.valid, ok, "Cas %s", name)
+	}
+}
+
+// TestJWTDecoderJWKS vérifie les signatures RS256 et ES256 avec un fichier JWKS
+func TestJWTDecoderJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "oct", "kid": "ignoree", "k": "c2VjcmV0"},
+	}})
+	assert.NoError(t, err)
+
+	decoder, err := newJWTDecoder(Config{JWTJWKSFile: writeConfigFile(t, "jwks.json", string(jwks))})
+	assert.NoError(t, err)
+	assert.Len(t, decoder.keys, 2, "Les clés symétriques doivent être ignorées")
+
+	claims := map[string]interface{}{"sub": "alice"}
+	rs256 := signJWT(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims, func(signed []byte) []byte {
+		sum := sha256.Sum256(signed)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sum[:])
+		assert.NoError(t, err)
+		return sig
+	})
+	es256 := signJWT(t, map[string]interface{}{"alg": "ES256", "kid": "ec-1"}, claims, func(signed []byte) []byte {
+		sum := sha256.Sum256(signed)
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, sum[:])
+		assert.NoError(t, err)
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	})
+
+	for name, token := range map[string]string{"RS256": rs256, "ES256": es256} {
+		got, ok := decoder.decode(bearerHeader(token))
+		assert.True(t, ok)
+		assert.True(t, got.verified, "La signature %s doit être vérifiée", name)
+		assert.Equal(t, "alice", got.claim("sub"))
+	}
+
+	// Charge utile modifiée après signature
+	parts := strings.Split(rs256, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + "." + parts[2]
+	got, _ := decoder.decode(bearerHeader(tampered))
+	assert.False(t, got.verified, "Un jeton modifié ne doit pas être vérifié")
+	assert.Equal(t, "mallory", got.claim("sub"))
+
+	// Jeton non signé
+	none := signJWT(t, map[string]interface{}{"alg": "none"}, claims, func([]byte) []byte { return nil })
+	got, _ = decoder.decode(bearerHeader(none))
+	assert.False(t, got.verified, "Un jeton sans signature ne doit pas être vérifié")
+}
+
+// TestRequestIdentityFromJWT vérifie l'identité journalisée et le masquage du jeton
+func TestRequestIdentityFromJWT(t *testing.T) {
+	handler, sink := newTestHandler(Config{
+		MaskHeaders: []string{"authorization"},
+		JWTSecret:   "secret-partage",
+	})
+
+	token := signJWT(t, map[string]interface{}{"alg": "HS256"},
+		map[string]interface{}{"preferred_username": "alice", "azp": "portail", "tid": "acme"}, hs256("secret-partage"))
+
+	withToken := newTestFlow("GET", "http://example.com/api", nil)
+	withToken.Request.Header = bearerHeader(token)
+	handler.Request(withToken)
+
+	spoofed := newTestFlow("GET", "http://example.com/usurpe", nil)
+	spoofed.Request.Header = bearerHeader(token)
+	spoofed.Request.Header.Set("username", "mallory")
+	handler.Request(spoofed)
+
+	forged := signJWT(t, map[string]interface{}{"alg": "HS256"},
+		map[string]interface{}{"preferred_username": "mallory", "azp": "portail"}, hs256("autre-secret"))
+	explicit := newTestFlow("GET", "http://example.com/explicite", nil)
+	explicit.Request.Header = bearerHeader(forged)
+	explicit.Request.Header.Set("username", "bob")
+	handler.Request(explicit)
+
+	anonymous := newTestFlow("GET", "http://example.com/anonyme", nil)
+	handler.Request(anonymous)
+	handler.Close()
+
+	for _, entry := range sink.entries(t, "create") {
+		switch entry.HTTPUrl {
+		case "http://example.com/api":
+			assert.Equal(t, "alice", entry.User)
+			assert.Equal(t, "portail", entry.ClientName)
+			assert.Equal(t, "acme", entry.Tenant)
+			if assert.NotNil(t, entry.JWTVerified) {
+				assert.True(t, *entry.JWTVerified)
+			}
+			assert.Equal(t, "********", entry.HTTPHeaders["Authorization"], "Le jeton doit rester masqué")
+		case "http://example.com/usurpe":
+			assert.Equal(t, "alice", entry.User, "Un jeton vérifié doit l'emporter sur les en-têtes")
+		case "http://example.com/explicite":
+			assert.Equal(t, "bob", entry.User, "Les en-têtes doivent l'emporter sur un jeton non vérifié")
+			assert.Equal(t, "portail", entry.ClientName)
+		case "http://example.com/anonyme":
+			assert.Equal(t, "Anonyme", entry.User)
+			assert.Nil(t, entry.JWTVerified, "Sans jeton, la vérification ne doit pas être renseignée")
+		}
+	}
+}
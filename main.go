@@ -1,14 +1,22 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"expvar"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,83 +26,316 @@ import (
 
 // LogModel - Structure pour la journalisation des requêtes et réponses
 type LogModel struct {
-	ID             string            `json:"id,omitempty"`
-	CorrelationID  string            `json:"correlation_id"`
-	ClientName     string            `json:"client_name"`
-	User           string            `json:"user"`
-	OccuredTime    time.Time         `json:"occured_time"`
-	HTTPMethod     string            `json:"http_method"`
-	HTTPUrl        string            `json:"http_url"`
-	HTTPHeaders    map[string]string `json:"http_headers"`
-	HTTPBody       string            `json:"http_body"`
-	LogTextShort   string            `json:"log_text_short"`
-	LogText        string            `json:"log_text"`
-	HTTPReturnCode int               `json:"http_return_code,omitempty"`
-	HTTPReturnBody string            `json:"http_response_body,omitempty"`
-	ExecutionTime  int64             `json:"execution_time,omitempty"`
-	LogType        string            `json:"log_type,omitempty"` // "info", "error", "critical"
+	ID                  string            `json:"id,omitempty"`
+	CorrelationID       string            `json:"correlation_id"`
+	ClientName          string            `json:"client_name"`
+	User                string            `json:"user"`
+	OccuredTime         time.Time         `json:"occured_time"`
+	HTTPMethod          string            `json:"http_method"`
+	HTTPUrl             string            `json:"http_url"`
+	HTTPHeaders         map[string]string `json:"http_headers"`
+	HTTPBody            string            `json:"http_body"`
+	LogTextShort        string            `json:"log_text_short"`
+	LogText             string            `json:"log_text"`
+	HTTPReturnCode      int               `json:"http_return_code,omitempty"`
+	HTTPReturnBody      string            `json:"http_response_body,omitempty"`
+	HTTPResponseHeaders map[string]string `json:"http_response_headers,omitempty"`
+	ExecutionTime       int64             `json:"execution_time,omitempty"`
+	LogType             string            `json:"log_type,omitempty"`       // "info", "error", "critical"
+	PIIRedactions       map[string]int    `json:"pii_redactions,omitempty"` // Données personnelles masquées par type
+
+	// Métadonnées de capture des corps (taille d'origine, troncature, encodage)
+	HTTPBodySize            int    `json:"http_body_size,omitempty"`
+	HTTPBodyTruncated       bool   `json:"http_body_truncated,omitempty"`
+	HTTPBodyEncoding        string `json:"http_body_encoding,omitempty"` // "base64" ou "omitted"
+	HTTPReturnBodySize      int    `json:"http_response_body_size,omitempty"`
+	HTTPReturnBodyTruncated bool   `json:"http_response_body_truncated,omitempty"`
+	HTTPReturnBodyEncoding  string `json:"http_response_body_encoding,omitempty"`
+
+	// Décompression du corps de réponse (Content-Encoding d'origine et taille compressée)
+	HTTPReturnContentEncoding string `json:"http_response_content_encoding,omitempty"`
+	HTTPReturnCompressedSize  int    `json:"http_response_compressed_size,omitempty"`
+	HTTPReturnDecodeError     string `json:"http_response_decode_error,omitempty"`
+
+	// Échec de la requête vers le serveur amont (dns, connect, tls, timeout, reset)
+	ErrorKind    string `json:"error_kind,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+
+	// Identité lue dans un jeton JWT Bearer: locataire et vérification de la signature
+	Tenant      string `json:"tenant,omitempty"`
+	JWTVerified *bool  `json:"jwt_verified,omitempty"`
+
+	// Client et utilisateur authentifiés auprès du proxy (Proxy-Authorization)
+	ProxyAuthenticated bool `json:"proxy_authenticated,omitempty"`
+
+	// Contexte de trace W3C: trace, passage par le proxy et parent reçu du client
+	TraceID      string `json:"trace_id,omitempty"`
+	SpanID       string `json:"span_id,omitempty"`
+	ParentSpanID string `json:"parent_span_id,omitempty"`
+
+	// Action de capture appliquée au flux ("metadata": corps non journalisés)
+	CaptureMode string `json:"capture_mode,omitempty"`
+
+	// Décision d'échantillonnage (absente si l'échantillonnage est désactivé)
+	Sampling *SamplingDecision `json:"sampling,omitempty"`
+
+	// Pile d'appels d'une panique interceptée dans un hook
+	Stacktrace string `json:"stacktrace,omitempty"`
+
+	// Décomposition de la durée du flux (connexion, TLS, attente, transfert)
+	Timings *Timings `json:"timings,omitempty"`
+
+	// Paramètres TLS et certificat du serveur amont (HTTPS uniquement)
+	TLS *TLSInfo `json:"tls,omitempty"`
 }
 
 // Config - Configuration du proxy MITM
 type Config struct {
-	LoggerEndpoint string
-	MaxRetries     int
-	RetryDelay     time.Duration
-	ExcludedRoutes []string
-	MaskHeaders    []string
-	WebInterface   bool
-	ProxyPort      int // Renommé de WebPort à ProxyPort pour plus de clarté
-	WebPort        int
+	LoggerEndpoint string        `yaml:"logger_endpoint"`
+	MaxRetries     int           `yaml:"max_retries"`
+	RetryDelay     time.Duration `yaml:"retry_delay"`
+	ExcludedRoutes []string      `yaml:"excluded_routes"`
+	MaskHeaders    []string      `yaml:"mask_headers"`  // Règles "motif" ou "motif=mode" (voir MaskRule)
+	MaskRules      []MaskRule    `yaml:"mask_rules"`    // Règles de masquage supplémentaires
+	MaskMode       string        `yaml:"mask_mode"`     // Mode par défaut: "redact", "partial" ou "hash"
+	MaskHashKey    string        `yaml:"mask_hash_key"` // Clé HMAC du mode "hash"
+
+	// Règles de capture par hôte, chemin, méthode, en-tête et client, évaluées
+	// dans l'ordre avant ExcludedRoutes, et action si aucune ne correspond
+	CaptureRules   []CaptureRule `yaml:"capture_rules"`
+	CaptureDefault string        `yaml:"capture_default"` // "skip", "metadata" ou "full"
+
+	// Échantillonnage: taux global et par route (0 à 1), flux toujours conservés
+	// (erreurs, lenteur) et sort des flux écartés ("skip" ou "metadata")
+	SampleRate          float64       `yaml:"sample_rate"`
+	SampleRules         []SampleRule  `yaml:"sample_rules"`
+	SampleKeepErrors    bool          `yaml:"sample_keep_errors"`
+	SampleKeepSlow      time.Duration `yaml:"sample_keep_slow"` // 0 = désactivé
+	SampleByCorrelation bool          `yaml:"sample_by_correlation"`
+	SampleOutAction     string        `yaml:"sample_out_action"`
+
+	// Chaînes d'extraction des champs d'identité: sources "header:", "query:",
+	// "cookie:", "jwt:", "verified_jwt:" ou "static:" essayées dans l'ordre
+	// (la source "cert:" est refusée, voir parseIdentityChain)
+	IdentityClient      []string `yaml:"identity_client"`
+	IdentityUser        []string `yaml:"identity_user"`
+	IdentityCorrelation []string `yaml:"identity_correlation"` // à défaut, l'ID de la requête
+	IdentityTenant      []string `yaml:"identity_tenant"`
+
+	// Propagation vers le serveur amont, et renvoi au client, de l'ID de
+	// corrélation et du contexte de trace W3C (en-tête traceparent)
+	PropagateCorrelation  bool `yaml:"propagate_correlation"`
+	PropagateTraceContext bool `yaml:"propagate_trace_context"`
+
+	// Authentification des clients du proxy (en-tête Proxy-Authorization):
+	// fichier "utilisateur:hash_bcrypt[:client]" et jetons "client:jeton"
+	ProxyAuthFile   string   `yaml:"proxy_auth_file"`
+	ProxyAuthTokens []string `yaml:"proxy_auth_tokens"`
+	ProxyAuthRealm  string   `yaml:"proxy_auth_realm"`
+
+	// Vérification des jetons JWT "Authorization: Bearer" lus par les sources
+	// "jwt:": clé partagée (HS*) ou fichier JWKS (RS*, PS*, ES*)
+	JWTSecret   string `yaml:"jwt_secret"`
+	JWTJWKSFile string `yaml:"jwt_jwks_file"`
+
+	// Masquage des paramètres d'URL et des cookies (même syntaxe que MaskHeaders)
+	MaskQueryParams []string `yaml:"mask_query_params"`
+	MaskCookies     []string `yaml:"mask_cookies"`
+
+	// Masquage des champs des corps JSON par hôte et route
+	BodyRedactRules []BodyRedactRule `yaml:"body_redact_rules"`
+
+	// Détecteurs de données personnelles actifs (vide = désactivé)
+	PIIDetectors []string `yaml:"pii_detectors"`
+
+	// Capture des corps: taille maximale journalisée (-1 = sans limite) et types exclus
+	MaxRequestBody       int      `yaml:"max_request_body"`
+	MaxResponseBody      int      `yaml:"max_response_body"`
+	SkipBodyContentTypes []string `yaml:"skip_body_content_types"`
+
+	// Décompression des corps de réponse pour la journalisation
+	DecodeBodies          bool `yaml:"decode_bodies"`
+	MaxDecompressedBody   int  `yaml:"max_decompressed_body"`
+	MaxDecompressionRatio int  `yaml:"max_decompression_ratio"`
+
+	WebInterface bool          `yaml:"web_interface"`
+	ProxyPort    int           `yaml:"proxy_port"` // Renommé de WebPort à ProxyPort pour plus de clarté
+	WebPort      int           `yaml:"web_port"`
+	MetricsPort  int           `yaml:"metrics_port"` // Port des métriques expvar (0 = désactivé)
+	FlowTTL      time.Duration `yaml:"flow_ttl"`     // Délai au-delà duquel un flux sans réponse est journalisé en timeout
+
+	// Intervalle de surveillance du fichier de configuration pour le rechargement à chaud (0 = désactivé)
+	ConfigWatchInterval time.Duration `yaml:"config_watch_interval"`
+
+	// Journalisation des ouvertures et fermetures de connexions client et serveur
+	LogConnections bool `yaml:"log_connections"`
+
+	// Délai en jours sous lequel un certificat serveur est signalé comme expirant
+	CertExpiryWarnDays int `yaml:"cert_expiry_warn_days"`
+
+	// Spool disque pour les journaux non livrés (désactivé si SpoolDir est vide)
+	SpoolDir           string        `yaml:"spool_dir"`
+	SpoolSegmentBytes  int64         `yaml:"spool_segment_bytes"`
+	SpoolMaxBytes      int64         `yaml:"spool_max_bytes"`
+	SpoolFsync         string        `yaml:"spool_fsync"` // "always", "interval" ou "never"
+	SpoolDrainInterval time.Duration `yaml:"spool_drain_interval"`
+
+	// Envoi par lots vers le point de terminaison bulk du logger
+	BatchEnabled    bool          `yaml:"batch_enabled"`
+	BatchEndpoint   string        `yaml:"batch_endpoint"`
+	BatchFormat     string        `yaml:"batch_format"` // "json" ou "ndjson"
+	BatchMaxCount   int           `yaml:"batch_max_count"`
+	BatchMaxBytes   int           `yaml:"batch_max_bytes"`
+	BatchMaxLatency time.Duration `yaml:"batch_max_latency"`
+
+	// File d'envoi des journaux consommée par un pool de workers
+	LogWorkers       int    `yaml:"log_workers"`
+	LogQueueSize     int    `yaml:"log_queue_size"`
+	LogQueueOverflow string `yaml:"log_queue_overflow"` // "block", "drop_oldest", "drop_newest" ou "spill"
+
+	// Sorties de journalisation actives ("logger", "file", "stdout")
+	Sinks             []string `yaml:"log_sinks"`
+	LogFilePath       string   `yaml:"log_file_path"`
+	LogFileMaxBytes   int64    `yaml:"log_file_max_bytes"`
+	LogFileMaxBackups int      `yaml:"log_file_max_backups"`
 }
 
 // MITMHandler - Gestionnaire pour le proxy MITM
 type MITMHandler struct {
-	config     Config
-	httpClient *http.Client
-	flowData   map[string]*LogModel
+	config   Config
+	flows    *flowStore
+	sinks    []Sink
+	logger   *loggerSink
+	queue    *logQueue
+	rules    atomic.Pointer[ruleSet] // règles de capture et de masquage, rechargeables
+	sampler  *sampler
+	identity *identityExtractor
+	auth     *proxyAuthenticator // nil si l'authentification est désactivée
+	timings  *timingTracker
+	conns    *connTracker
+	panics   atomic.Int64 // paniques interceptées dans les hooks
 }
 
 // NewMITMHandler - Créer un nouveau gestionnaire MITM avec la configuration donnée
+//
+// La configuration est prise telle quelle: les valeurs par défaut sont
+// fixées par defaultConfig et loadConfig, une valeur nulle est respectée.
 func NewMITMHandler(config Config) *MITMHandler {
-	// Valeurs par défaut si non fournies
-	if config.MaxRetries == 0 {
-		config.MaxRetries = 3
+	// Clé HMAC éphémère commune à tous les masqueurs, conservée lors des rechargements
+	if config.MaskHashKey == "" {
+		key := make([]byte, 32)
+		rand.Read(key)
+		config.MaskHashKey = hex.EncodeToString(key)
 	}
-	if config.RetryDelay == 0 {
-		config.RetryDelay = 500 * time.Millisecond
+
+	h := &MITMHandler{
+		config: config,
+	}
+
+	// Capturer les erreurs amont journalisées par go-mitmproxy
+	registerUpstreamErrorHook()
+
+	// Compiler les règles de capture et de masquage
+	rules, err := compileRules(rulesOf(config))
+	if err != nil {
+		log.Printf("Règles invalides ignorées: %v", err)
 	}
-	if len(config.MaskHeaders) == 0 {
-		config.MaskHeaders = []string{"authorization", "password", "token", "api-key"}
+	h.rules.Store(rules)
+	h.sampler, err = newSampler(config)
+	if err != nil {
+		log.Printf("Échantillonnage: paramètres invalides ignorés: %v", err)
 	}
-	if config.ProxyPort == 0 {
-		config.ProxyPort = 9080
+	metrics.Set("sampled_out", expvar.Func(func() any { return h.sampler.sampledOut.Load() }))
+	h.identity, err = newIdentityExtractor(config)
+	if err != nil {
+		log.Printf("Extraction de l'identité: %v", err)
 	}
-	if config.WebPort == 0 {
-		config.WebPort = 9081
+	h.auth, err = newProxyAuthenticator(config)
+	if err != nil {
+		log.Printf("Authentification au proxy: %v", err)
 	}
-
-	// Créer le client HTTP
-	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
+	if h.auth != nil {
+		metrics.Set("proxy_auth_failures", expvar.Func(func() any { return h.auth.failures.Load() }))
+	}
+	h.timings = newTimingTracker()
+	h.conns = newConnTracker()
+	h.flows = newFlowStore(config.FlowTTL, h.logTimeout)
+	metrics.Set("flows_pending", expvar.Func(func() any { return h.flows.Len() }))
+
+	// Créer les sorties de journalisation configurées
+	for _, name := range config.Sinks {
+		switch strings.TrimSpace(name) {
+		case SinkLogger:
+			h.logger = newLoggerSink(config)
+			h.sinks = append(h.sinks, h.logger)
+		case SinkFile:
+			sink, err := newFileSink(config.LogFilePath, config.LogFileMaxBytes, config.LogFileMaxBackups)
+			if err != nil {
+				log.Printf("Sortie fichier désactivée: %v", err)
+				continue
+			}
+			h.sinks = append(h.sinks, sink)
+		case SinkStdout:
+			h.sinks = append(h.sinks, newStdoutSink(os.Stdout))
+		case "":
+		default:
+			log.Printf("Sortie de journalisation inconnue ignorée: %q", name)
+		}
 	}
 
-	return &MITMHandler{
-		config:     config,
-		httpClient: httpClient,
-		flowData:   make(map[string]*LogModel),
+	// Démarrer le pool de workers d'envoi
+	h.queue = newLogQueue(config.LogWorkers, config.LogQueueSize, config.LogQueueOverflow, h.deliverJob, h.spillJob)
+	metrics.Set("queue_length", expvar.Func(func() any { return h.queue.Len() }))
+	metrics.Set("queue_enqueued", expvar.Func(func() any { return h.queue.enqueued.Load() }))
+	metrics.Set("queue_dropped_oldest", expvar.Func(func() any { return h.queue.droppedOldest.Load() }))
+	metrics.Set("queue_dropped_newest", expvar.Func(func() any { return h.queue.droppedNewest.Load() }))
+	metrics.Set("queue_spilled", expvar.Func(func() any { return h.queue.spilled.Load() }))
+	metrics.Set("panics", expvar.Func(func() any { return h.panics.Load() }))
+
+	return h
+}
+
+// Close - Envoyer les journaux en attente puis fermer les sorties
+func (h *MITMHandler) Close() error {
+	h.queue.Close()
+	h.flows.Close()
+
+	var firstErr error
+	for _, sink := range h.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
 }
 
 // Request - Intercepte les requêtes entrantes
 func (h *MITMHandler) Request(f *proxy.Flow) {
+	defer h.recoverPanic("Request", f, nil)
+
 	req := f.Request
 
-	// Vérifier si la route doit être exclue
-	for _, route := range h.config.ExcludedRoutes {
-		if strings.Contains(req.URL.String(), route) {
-			return
+	// Les règles en vigueur au début du flux s'appliquent jusqu'à sa fin
+	rules := h.rules.Load()
+
+	// Extraire l'identité selon les chaînes configurées
+	identity := h.identity.extract(req)
+	proxyAuthenticated := false
+	if h.auth != nil {
+		// L'identité authentifiée auprès du proxy prime sur les en-têtes déclarés
+		if authenticated, ok := h.auth.identityOf(f); ok {
+			identity.Client, identity.User = authenticated.Client, authenticated.User
+			proxyAuthenticated = true
 		}
 	}
+	clientName := identity.Client
+
+	// Vérifier si le flux doit être journalisé, et avec quels corps
+	capture := rules.captureAction(req, clientName)
+	if capture == CaptureSkip {
+		return
+	}
+	h.timings.Request(f)
 
 	// Enregistrer l'heure de début pour calculer le temps d'exécution
 	startTime := time.Now()
@@ -102,66 +343,75 @@ func (h *MITMHandler) Request(f *proxy.Flow) {
 	// Générer un ID unique pour cette requête
 	requestID := uuid.New().String()
 
-	// Extraire les informations de la requête
-	clientName := req.Header.Get("client-name")
-	if clientName == "" {
-		clientName = "Anonyme"
-	}
-
-	correlationID := req.Header.Get("correlation-id")
+	correlationID := identity.CorrelationID
 	if correlationID == "" {
 		correlationID = requestID
 	}
 
-	user := req.Header.Get("username")
-	if user == "" {
-		user = req.Header.Get("user")
-	}
-	if user == "" {
-		user = "Anonyme"
-	}
+	// Transmettre l'ID de corrélation et le contexte de trace au serveur amont
+	trace := h.propagateContext(req, correlationID)
 
 	// Créer une map pour les en-têtes HTTP
-	headers := make(map[string]string)
-	for name, values := range req.Header {
-		// Masquer les en-têtes sensibles
-		headerLower := strings.ToLower(name)
-		for _, mask := range h.config.MaskHeaders {
-			if headerLower == strings.ToLower(mask) {
-				headers[name] = "********"
-				continue
-			}
-		}
-		headers[name] = strings.Join(values, ", ")
-	}
+	headers := rules.maskHeaders(req.Header)
 
-	// Lire le corps de la requête
-	var bodyBytes []byte
-	if req.Body != nil {
-		bodyBytes = req.Body
+	// URL journalisée sans paramètres sensibles ni données personnelles
+	piiCounts := make(map[string]int)
+	logURL := rules.logURL(req.URL, piiCounts)
+
+	// Lire le corps de la requête en masquant les données sensibles
+	body := omittedBody(req.Body)
+	if capture == CaptureFull {
+		body = rules.captureBody(req.URL, req.Header.Get("Content-Type"), req.Body, h.config.MaxRequestBody, piiCounts)
 	}
 
 	// Créer l'entrée de journal initiale
 	logEntry := &LogModel{
-		ID:            requestID,
-		CorrelationID: correlationID,
-		ClientName:    clientName,
-		User:          user,
-		OccuredTime:   time.Now(),
-		HTTPMethod:    req.Method,
-		HTTPUrl:       req.URL.String(),
-		HTTPHeaders:   headers,
-		HTTPBody:      string(bodyBytes),
-		LogTextShort:  "Requête interceptée",
-		LogText:       fmt.Sprintf("Requête interceptée: %s %s", req.Method, req.URL.String()),
-		LogType:       "info",
-	}
-
-	// Envoyer le journal initial au service de journalisation
-	go h.sendLogToLogger(logEntry, "create")
-
-	// Stocker les données pour les récupérer dans Response
-	h.flowData[f.Id.String()] = logEntry
+		ID:                 requestID,
+		CorrelationID:      correlationID,
+		ClientName:         clientName,
+		User:               identity.User,
+		Tenant:             identity.Tenant,
+		JWTVerified:        identity.JWTVerified,
+		ProxyAuthenticated: proxyAuthenticated,
+		TraceID:            trace.TraceID,
+		SpanID:             trace.SpanID,
+		ParentSpanID:       trace.ParentSpanID,
+		OccuredTime:        time.Now(),
+		HTTPMethod:         req.Method,
+		HTTPUrl:            logURL,
+		HTTPHeaders:        headers,
+		HTTPBody:           body.Body,
+		LogTextShort:       "Requête interceptée",
+		LogText:            fmt.Sprintf("Requête interceptée: %s %s", req.Method, logURL),
+		LogType:            "info",
+
+		HTTPBodySize:      body.Size,
+		HTTPBodyTruncated: body.Truncated,
+		HTTPBodyEncoding:  body.Encoding,
+
+		CaptureMode: capture,
+	}
+	if len(piiCounts) > 0 {
+		logEntry.PIIRedactions = piiCounts
+	}
+
+	// Envoyer le journal initial au service de journalisation, sauf si le flux
+	// est écarté par l'échantillonnage: il ne sera envoyé qu'à sa fin
+	logEntry.Sampling = h.sampler.decide(req, clientName, correlationID)
+	var create logJob
+	kept := logEntry.Sampling == nil || logEntry.Sampling.Kept
+	if kept {
+		// Sérialiser avant le stockage, où l'entrée peut être évincée et modifiée
+		create, kept = h.logJob(logEntry, "create")
+	}
+
+	// Stocker les données pour les récupérer dans Response, avant l'envoi de
+	// la création: une panique ultérieure finalise cette entrée (recoverPanic)
+	// au lieu d'en créer une seconde
+	h.flows.Put(f.Id.String(), logEntry, rules)
+	if kept {
+		h.queue.Push(create)
+	}
 
 	// Ecrire en console le temps d'exécution
 	log.Printf("Temps d'exécution: %d ms", time.Since(startTime).Milliseconds())
@@ -169,8 +419,11 @@ func (h *MITMHandler) Request(f *proxy.Flow) {
 
 // Response - Intercepte les réponses
 func (h *MITMHandler) Response(f *proxy.Flow) {
+	var logEntry *LogModel
+	defer h.recoverPanic("Response", f, &logEntry)
+
 	// Récupérer les données stockées
-	logEntry, ok := h.flowData[f.Id.String()]
+	logEntry, rules, ok := h.flows.Take(f.Id.String())
 	if !ok {
 		return
 	}
@@ -178,18 +431,46 @@ func (h *MITMHandler) Response(f *proxy.Flow) {
 	resp := f.Response
 	startTime := logEntry.OccuredTime
 
-	// Lire le corps de la réponse
-	var responseBodyBytes []byte
-	if resp.Body != nil {
-		responseBodyBytes = resp.Body
+	// Décompresser le corps pour le journal, sans modifier ce que reçoit le client
+	responseBody := resp.Body
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	fullCapture := logEntry.CaptureMode != CaptureMetadata
+	if fullCapture && h.config.DecodeBodies && len(responseBody) > 0 && contentEncoding != "" && !strings.EqualFold(contentEncoding, "identity") {
+		logEntry.HTTPReturnContentEncoding = contentEncoding
+		logEntry.HTTPReturnCompressedSize = len(responseBody)
+		decoded, err := decodeContent(contentEncoding, responseBody, h.config.MaxDecompressedBody, h.config.MaxDecompressionRatio)
+		if err != nil {
+			logEntry.HTTPReturnDecodeError = err.Error()
+		} else {
+			responseBody = decoded
+		}
+	}
+
+	// Lire le corps de la réponse en masquant les données sensibles
+	piiCounts := make(map[string]int)
+	body := omittedBody(responseBody)
+	if fullCapture {
+		body = rules.captureBody(f.Request.URL, resp.Header.Get("Content-Type"), responseBody, h.config.MaxResponseBody, piiCounts)
+	}
+	for name, n := range piiCounts {
+		if logEntry.PIIRedactions == nil {
+			logEntry.PIIRedactions = make(map[string]int)
+		}
+		logEntry.PIIRedactions[name] += n
 	}
 
 	// Mettre à jour le journal avec les données de réponse
 	executionTime := time.Since(startTime).Milliseconds()
 
 	logEntry.HTTPReturnCode = resp.StatusCode
-	logEntry.HTTPReturnBody = string(responseBodyBytes)
+	logEntry.HTTPResponseHeaders = rules.maskHeaders(resp.Header)
+	logEntry.HTTPReturnBody = body.Body
+	logEntry.HTTPReturnBodySize = body.Size
+	logEntry.HTTPReturnBodyTruncated = body.Truncated
+	logEntry.HTTPReturnBodyEncoding = body.Encoding
 	logEntry.ExecutionTime = executionTime
+	logEntry.Timings = h.timings.Finish(f)
+	logEntry.TLS = h.flowTLSInfo(f)
 
 	// Mettre à jour le texte du journal en fonction du code d'état
 	if resp.StatusCode >= 400 {
@@ -205,16 +486,215 @@ func (h *MITMHandler) Response(f *proxy.Flow) {
 	}
 
 	// Envoyer le journal mis à jour au service de journalisation
-	go h.sendLogToLogger(logEntry, "update")
-
-	// Nettoyer les données stockées
-	delete(h.flowData, f.Id.String())
+	h.queueFinal(logEntry)
 }
 
 // Done - Appelé lorsque le flux est terminé
+//
+// Un flux encore en attente n'a pas été finalisé par Response: soit la
+// requête amont a échoué, soit la réponse a été diffusée en streaming.
 func (h *MITMHandler) Done(f *proxy.Flow) {
-	// Nettoyer les données stockées si ce n'est pas déjà fait
-	delete(h.flowData, f.Id.String())
+	defer h.recoverPanic("Done", f, nil)
+	defer h.timings.Forget(f)
+
+	if h.config.LogConnections && f.Request.Method != http.MethodConnect {
+		sent := 0
+		if f.Response != nil {
+			sent = len(f.Response.Body)
+		}
+		h.conns.AddFlow(f.ConnContext, len(f.Request.Body), sent)
+	}
+
+	if f.Request.Method == http.MethodConnect {
+		h.connectDone(f)
+		return
+	}
+	if f.Response == nil {
+		h.Error(f)
+		return
+	}
+	h.Response(f)
+}
+
+// connectDone - Journaliser l'échec d'un tunnel CONNECT vers le serveur amont
+//
+// Par défaut (UpstreamCert), go-mitmproxy contacte le serveur pendant le
+// CONNECT: un échec DNS, de connexion ou de poignée de main TLS survient
+// avant toute requête, et Request n'est jamais appelé. Le CONNECT est alors
+// journalisé comme une requête vers le serveur visé.
+func (h *MITMHandler) connectDone(f *proxy.Flow) {
+	// Échec de la connexion amont: journalisé avec l'adresse du serveur visé
+	message, ok := upstreamErrors.Take(http.MethodConnect, f.Request.URL.Host)
+
+	// Échec de la poignée de main TLS: journalisé avec l'adresse du client,
+	// et imputable au serveur seulement si la poignée de main amont n'a pas abouti
+	tlsMessage, tlsFailed := "", false
+	if client := clientConnOf(f); client != nil && client.Conn != nil {
+		tlsMessage, tlsFailed = upstreamErrors.Take(http.MethodConnect, client.Conn.RemoteAddr().String())
+		server := f.ConnContext.ServerConn
+		tlsFailed = tlsFailed && server != nil && server.TlsState() == nil
+	}
+
+	switch {
+	case f.Response == nil:
+		// Tunnel jamais ouvert
+	case tlsFailed:
+		message, ok = tlsMessage, true
+	default:
+		return
+	}
+
+	f.Request.URL = &url.URL{Scheme: "https", Host: f.Request.URL.Host}
+	h.Request(f)
+	h.failFlow(f, message, ok)
+}
+
+// watchFlow - Appeler Done à la fin du flux (go-mitmproxy n'a pas de hook de fin)
+func (h *MITMHandler) watchFlow(f *proxy.Flow) {
+	done := f.Done()
+	if done == nil {
+		return
+	}
+	go func() {
+		<-done
+		h.Done(f)
+	}()
+}
+
+// Error - Journaliser l'échec d'une requête vers le serveur amont
+func (h *MITMHandler) Error(f *proxy.Flow) {
+	defer h.recoverPanic("Error", f, nil)
+
+	message, ok := upstreamErrors.Take(f.Request.Method, f.Request.URL.String())
+	h.failFlow(f, message, ok)
+}
+
+// failFlow - Finaliser un flux en attente avec l'erreur amont journalisée par la bibliothèque
+//
+// Sans message (erreur journalisée au niveau Debug, ou pas du tout), la
+// catégorie est déduite de l'état de la connexion amont.
+func (h *MITMHandler) failFlow(f *proxy.Flow, message string, ok bool) {
+	var logEntry *LogModel
+	defer h.recoverPanic("Error", f, &logEntry)
+
+	logEntry, _, found := h.flows.Take(f.Id.String())
+	if !found {
+		return
+	}
+
+	kind := classifyUpstreamError(message)
+	// Le message de net/http reprend l'URL brute: la remplacer par l'URL masquée
+	message = strings.ReplaceAll(message, f.Request.URL.String(), logEntry.HTTPUrl)
+	if !ok {
+		message = "Aucune réponse du serveur amont"
+		kind = ErrorKindUnknown
+		if f.ConnContext != nil {
+			if f.ConnContext.ServerConn == nil {
+				kind = ErrorKindConnect
+			} else {
+				kind = ErrorKindReset
+			}
+		}
+	}
+
+	logEntry.ErrorKind = kind
+	logEntry.ErrorMessage = message
+	logEntry.HTTPReturnCode = upstreamErrorStatus(kind)
+	logEntry.ExecutionTime = time.Since(logEntry.OccuredTime).Milliseconds()
+	logEntry.Timings = h.timings.Finish(f)
+	logEntry.TLS = h.flowTLSInfo(f)
+	logEntry.LogTextShort = fmt.Sprintf("Erreur amont (%s)", kind)
+	logEntry.LogText = fmt.Sprintf("Échec de la requête %s %s: %s", logEntry.HTTPMethod, logEntry.HTTPUrl, message)
+	logEntry.LogType = "critical"
+
+	h.queueFinal(logEntry)
+}
+
+// HTTPError - Alias de Error pour les erreurs survenues pendant l'échange HTTP
+func (h *MITMHandler) HTTPError(f *proxy.Flow) {
+	defer h.recoverPanic("HTTPError", f, nil)
+
+	h.Error(f)
+}
+
+// Requestheaders - En-têtes de la requête reçus: début du flux
+func (h *MITMHandler) Requestheaders(f *proxy.Flow) {
+	defer h.recoverPanic("Requestheaders", f, nil)
+
+	if h.auth != nil && !h.auth.admit(f) {
+		return
+	}
+	h.timings.RequestHeaders(f)
+	h.watchFlow(f)
+}
+
+// Responseheaders - En-têtes de la réponse reçus du serveur
+func (h *MITMHandler) Responseheaders(f *proxy.Flow) {
+	defer h.recoverPanic("Responseheaders", f, nil)
+
+	h.timings.ResponseHeaders(f)
+	h.flows.Responding(f.Id.String())
+	h.echoContext(f)
+}
+
+// ServerConnected - Connexion établie avec le serveur amont
+func (h *MITMHandler) ServerConnected(ctx *proxy.ConnContext) {
+	defer h.recoverPanic("ServerConnected", nil, nil)
+
+	h.timings.ServerConnected(ctx)
+	if h.config.LogConnections {
+		h.queueConnectionEvent(h.conns.serverConnected(ctx))
+	}
+}
+
+// ServerDisconnected - Connexion avec le serveur amont fermée
+func (h *MITMHandler) ServerDisconnected(ctx *proxy.ConnContext) {
+	defer h.recoverPanic("ServerDisconnected", nil, nil)
+
+	if h.config.LogConnections {
+		h.queueConnectionEvent(h.conns.serverDisconnected(ctx))
+	}
+}
+
+// TlsEstablishedServer - Poignée de main TLS terminée avec le serveur amont
+func (h *MITMHandler) TlsEstablishedServer(ctx *proxy.ConnContext) {
+	defer h.recoverPanic("TlsEstablishedServer", nil, nil)
+
+	h.timings.TLSEstablished(ctx)
+}
+
+// ClientConnected - Nouvelle connexion d'un client au proxy
+func (h *MITMHandler) ClientConnected(client *proxy.ClientConn) {
+	defer h.recoverPanic("ClientConnected", nil, nil)
+
+	if h.config.LogConnections {
+		h.queueConnectionEvent(h.conns.clientConnected(client))
+	}
+}
+
+// ClientDisconnected - Connexion du client fermée
+func (h *MITMHandler) ClientDisconnected(client *proxy.ClientConn) {
+	defer h.recoverPanic("ClientDisconnected", nil, nil)
+
+	h.timings.ClientDisconnected(client)
+	if h.auth != nil {
+		h.auth.forget(client)
+	}
+	if h.config.LogConnections {
+		h.queueConnectionEvent(h.conns.clientDisconnected(client))
+	}
+}
+
+// logTimeout - Journaliser un flux évincé faute de réponse dans le délai imparti
+func (h *MITMHandler) logTimeout(logEntry *LogModel) {
+	logEntry.HTTPReturnCode = http.StatusRequestTimeout
+	logEntry.ExecutionTime = time.Since(logEntry.OccuredTime).Milliseconds()
+	logEntry.LogTextShort = "Timeout"
+	logEntry.LogText = fmt.Sprintf("Aucune réponse reçue après %s: %s %s",
+		h.config.FlowTTL, logEntry.HTTPMethod, logEntry.HTTPUrl)
+	logEntry.LogType = "error"
+
+	h.queueFinal(logEntry)
 }
 
 // Requis par l'interface proxy.Addon
@@ -222,90 +702,98 @@ func (h *MITMHandler) ResponseHeader(f *proxy.Flow)
 func (h *MITMHandler) RequestHeader(f *proxy.Flow)                                  {}
 func (h *MITMHandler) Connect(f *proxy.Flow)                                        {}
 func (h *MITMHandler) Connected(f *proxy.Flow)                                      {}
-func (h *MITMHandler) Error(f *proxy.Flow)                                          {}
-func (h *MITMHandler) HTTPError(f *proxy.Flow)                                      {}
 func (h *MITMHandler) ParentProxy(*proxy.Flow) string                               { return "" }
 func (h *MITMHandler) AccessProxyServer(req *http.Request, res http.ResponseWriter) {}
 func (h *MITMHandler) StreamRequestModifier(f *proxy.Flow, in io.Reader) io.Reader  { return in }
 func (h *MITMHandler) StreamResponseModifier(f *proxy.Flow, in io.Reader) io.Reader { return in }
-func (h *MITMHandler) ClientConnected(client *proxy.ClientConn)                     {}
-func (h *MITMHandler) ClientDisconnected(client *proxy.ClientConn)                  {}
-func (h *MITMHandler) ServerConnected(ctx *proxy.ConnContext)                       {}
-func (h *MITMHandler) ServerDisconnected(ctx *proxy.ConnContext)                    {}
-func (h *MITMHandler) TlsEstablishedServer(ctx *proxy.ConnContext)                  {}
-func (h *MITMHandler) Requestheaders(f *proxy.Flow)                                 {}
-func (h *MITMHandler) Responseheaders(f *proxy.Flow)                                {}
-
-// sendLogToLogger - Envoyer une entrée de journal au service de journalisation
-func (h *MITMHandler) sendLogToLogger(logEntry *LogModel, action string) {
-	// Convertir l'entrée de journal en JSON
+
+// queueLog - Sérialiser une entrée de journal et la placer dans la file d'envoi
+//
+// La sérialisation est faite immédiatement: l'entrée peut ensuite être
+// modifiée par les hooks suivants sans affecter ce qui sera envoyé.
+func (h *MITMHandler) queueLog(logEntry *LogModel, action string) {
+	if job, ok := h.logJob(logEntry, action); ok {
+		h.queue.Push(job)
+	}
+}
+
+// logJob - Sérialiser une entrée de journal pour la file d'envoi
+func (h *MITMHandler) logJob(logEntry *LogModel, action string) (logJob, bool) {
 	jsonData, err := json.Marshal(logEntry)
 	if err != nil {
 		log.Printf("Erreur lors de la sérialisation de l'entrée de journal: %v", err)
-		return
-	}
-
-	// Déterminer le point de terminaison en fonction de l'action
-	endpoint := h.config.LoggerEndpoint
-	if action == "update" {
-		endpoint = fmt.Sprintf("%s/update", endpoint)
+		return logJob{}, false
 	}
+	return logJob{action: action, data: jsonData, key: logEntry.ID}, true
+}
 
-	// Envoyer le journal au logger avec des tentatives
-	var resp *http.Response
-	for i := 0; i <= h.config.MaxRetries; i++ {
-		req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(jsonData))
-		if err != nil {
-			log.Printf("Erreur lors de la création de la requête vers le logger: %v", err)
-			time.Sleep(h.config.RetryDelay)
-			continue
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		resp, err = h.httpClient.Do(req)
-		if err == nil && resp.StatusCode < 500 {
-			resp.Body.Close()
-			break
-		}
-
-		if resp != nil {
-			resp.Body.Close()
-		}
-
-		if i < h.config.MaxRetries {
-			// Backoff exponentiel
-			backoff := h.config.RetryDelay * time.Duration(1<<uint(i))
-			time.Sleep(backoff)
+// deliverJob - Distribuer une entrée de la file à chaque sortie (appelé par les workers)
+func (h *MITMHandler) deliverJob(job logJob) {
+	for _, sink := range h.sinks {
+		if err := sink.Write(job.action, job.data); err != nil {
+			log.Printf("Erreur de la sortie %s: %v", sink.Name(), err)
 		}
 	}
+}
 
-	if resp == nil || resp.StatusCode >= 400 {
-		log.Printf("Échec de l'envoi du journal au logger après %d tentatives", h.config.MaxRetries)
+// spillJob - Écrire dans le spool une entrée refusée par la file pleine
+//
+// Seule la sortie Logger dispose d'un spool: les autres sorties ne
+// recevront pas les entrées débordées.
+func (h *MITMHandler) spillJob(job logJob) bool {
+	if h.logger == nil {
+		return false
 	}
+	return h.logger.Spill(job.action, job.data)
 }
 
 func main() {
-	// Charger la configuration depuis les variables d'environnement
-	config := Config{
-		LoggerEndpoint: getEnv("LOGGER_ENDPOINT", "http://localhost:8080/api/logs"),
-		MaxRetries:     getEnvInt("MAX_RETRIES", 3),
-		RetryDelay:     getEnvDuration("RETRY_DELAY", 500*time.Millisecond),
-		ExcludedRoutes: strings.Split(getEnv("EXCLUDED_ROUTES", ""), ","),
-		MaskHeaders:    strings.Split(getEnv("MASK_HEADERS", "authorization,password,token,api-key"), ","),
-		WebInterface:   getEnvBool("WEB_INTERFACE", true),
-		ProxyPort:      getEnvInt("PROXY_PORT", 9080),
-		WebPort:        getEnvInt("WEB_PORT", 9081),
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "Fichier de configuration YAML ou JSON")
+	printConfig := flag.Bool("print-config", false, "Afficher la configuration effective (secrets masqués) puis quitter")
+	flag.Parse()
+
+	// Charger la configuration: valeurs par défaut, fichier puis variables d'environnement
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Configuration invalide:\n%v", err)
+	}
+
+	if *printConfig {
+		out, err := marshalConfig(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(string(out))
+		return
 	}
 
 	// Créer le gestionnaire MITM
 	handler := NewMITMHandler(config)
 
+	// Recharger les règles sur SIGHUP et à la modification du fichier de configuration
+	reloader := startConfigReloader(*configPath, config, handler)
+
 	// Configurer les options du proxy
 	opts := &proxy.Options{
 		Addr:              fmt.Sprintf(":%d", config.ProxyPort),
 		StreamLargeBodies: 1024 * 1024, // 1MB
 	}
 
+	// Avec l'authentification, une passerelle écoute sur PROXY_PORT pour répondre
+	// 407 aux CONNECT non authentifiés, et go-mitmproxy sur une adresse locale
+	var gate *authGate
+	if handler.auth != nil {
+		backend, err := freeLocalAddr()
+		if err != nil {
+			log.Fatal(err)
+		}
+		gate, err = newAuthGate(opts.Addr, backend, handler.auth)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Addr = backend
+	}
+
 	// Créer et démarrer le proxy
 	p, err := proxy.NewProxy(opts)
 	if err != nil {
@@ -315,6 +803,11 @@ func main() {
 	// Ajouter le gestionnaire MITM comme addon
 	p.AddAddon(handler)
 
+	// Les CONNECT parvenus sans passer par la passerelle échouent à la connexion amont
+	if handler.auth != nil {
+		p.SetUpstreamProxy(handler.auth.upstreamProxy(libraryUpstreamProxy(opts)))
+	}
+
 	// Configurer et démarrer l'interface web si activée
 	if config.WebInterface {
 		webAddr := fmt.Sprintf(":%d", config.WebPort)
@@ -323,45 +816,43 @@ func main() {
 		fmt.Printf("Interface web disponible sur http://localhost:%d\n", config.WebPort)
 	}
 
+	// Exposer les métriques si activées
+	if config.MetricsPort != 0 {
+		startMetricsServer(config.MetricsPort)
+	}
+
 	fmt.Printf("Proxy MITM démarré sur le port %d\n", config.ProxyPort)
-	log.Fatal(p.Start())
-}
 
-// Fonctions utilitaires pour les variables d'environnement
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+	// Arrêter proprement sur SIGINT/SIGTERM pour livrer la file, les lots et le spool
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	errs := make(chan error, 1)
+	go func() { errs <- p.Start() }()
+
+	exitCode := 0
+	select {
+	case err := <-errs:
+		log.Printf("Arrêt du proxy: %v", err)
+		exitCode = 1
+	case sig := <-signals:
+		log.Printf("Signal %s reçu, arrêt en cours", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := p.Shutdown(ctx); err != nil {
+			log.Printf("Erreur lors de l'arrêt du proxy: %v", err)
+		}
+		cancel()
 	}
-	return value
-}
-
-func getEnvBool(key string, defaultValue bool) bool {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+	if gate != nil {
+		gate.Close()
 	}
-	return value == "true" || value == "1" || value == "yes"
-}
 
-func getEnvInt(key string, defaultValue int) int {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+	reloader.Close()
+	if err := handler.Close(); err != nil {
+		log.Printf("Erreur lors de la fermeture des sorties: %v", err)
 	}
-	intValue := defaultValue
-	fmt.Sscanf(value, "%d", &intValue)
-	return intValue
+	os.Exit(exitCode)
 }
 
-func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
-	}
-	duration, err := time.ParseDuration(value)
-	if err != nil {
-		return defaultValue
-	}
-	return duration
-}
+// shutdownTimeout - Délai accordé aux connexions en cours lors de l'arrêt
+const shutdownTimeout = 10 * time.Second
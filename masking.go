@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Modes de masquage des valeurs sensibles
+const (
+	MaskModeRedact  = "redact"  // valeur entièrement remplacée
+	MaskModePartial = "partial" // seuls les 4 derniers caractères restent visibles
+	MaskModeHash    = "hash"    // empreinte HMAC-SHA256 pour corréler sans exposer
+)
+
+// maskedValue - Valeur de remplacement d'un champ masqué
+const maskedValue = "********"
+
+// MaskRule - Règle de masquage d'un en-tête
+//
+// Pattern accepte un nom exact ("authorization"), un préfixe
+// ("prefix:x-internal-"), un glob ("x-*-secret", "*token*") ou une
+// expression régulière ("re:^x-.*-key$"). La casse est ignorée.
+type MaskRule struct {
+	Pattern string `yaml:"pattern"`
+	Mode    string `yaml:"mode"` // "redact", "partial" ou "hash" (MaskMode si vide)
+}
+
+// compiledMaskRule - Règle de masquage prête à l'emploi
+type compiledMaskRule struct {
+	match func(name string) bool
+	mode  string
+}
+
+// headerMasker - Applique les règles de masquage aux en-têtes
+//
+// La même syntaxe de règles sert aussi pour les paramètres d'URL et les
+// noms de cookies.
+type headerMasker struct {
+	rules   []compiledMaskRule
+	hashKey []byte
+}
+
+// parseMaskRule - Lire une règle au format "motif" ou "motif=mode"
+func parseMaskRule(spec string) MaskRule {
+	spec = strings.TrimSpace(spec)
+	if i := strings.LastIndex(spec, "="); i > 0 {
+		switch mode := strings.ToLower(spec[i+1:]); mode {
+		case MaskModeRedact, MaskModePartial, MaskModeHash:
+			return MaskRule{Pattern: spec[:i], Mode: mode}
+		}
+	}
+	return MaskRule{Pattern: spec}
+}
+
+// newHeaderMasker - Compiler les règles de masquage
+//
+// Les règles invalides sont ignorées et signalées dans l'erreur retournée,
+// le masqueur restant utilisable avec les règles valides.
+func newHeaderMasker(rules []MaskRule, defaultMode, hashKey string) (*headerMasker, error) {
+	if defaultMode == "" {
+		defaultMode = MaskModeRedact
+	}
+
+	m := &headerMasker{hashKey: []byte(hashKey)}
+	var errs []error
+	for _, rule := range rules {
+		if strings.TrimSpace(rule.Pattern) == "" {
+			continue
+		}
+		compiled, err := compileMaskRule(rule, defaultMode)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		m.rules = append(m.rules, compiled)
+	}
+
+	// Sans clé, générer une clé éphémère: les empreintes ne seront pas stables entre redémarrages
+	if len(m.hashKey) == 0 {
+		m.hashKey = make([]byte, 32)
+		rand.Read(m.hashKey)
+	}
+
+	return m, errors.Join(errs...)
+}
+
+// compileMaskRule - Construire la fonction de correspondance d'une règle
+func compileMaskRule(rule MaskRule, defaultMode string) (compiledMaskRule, error) {
+	mode := strings.ToLower(rule.Mode)
+	if mode == "" {
+		mode = defaultMode
+	}
+	switch mode {
+	case MaskModeRedact, MaskModePartial, MaskModeHash:
+	default:
+		return compiledMaskRule{}, fmt.Errorf("mode de masquage inconnu %q pour %q", rule.Mode, rule.Pattern)
+	}
+
+	pattern := strings.TrimSpace(rule.Pattern)
+	compiled := compiledMaskRule{mode: mode}
+
+	switch {
+	case strings.HasPrefix(pattern, "re:"):
+		re, err := regexp.Compile("(?i)" + strings.TrimPrefix(pattern, "re:"))
+		if err != nil {
+			return compiledMaskRule{}, fmt.Errorf("expression régulière invalide %q: %w", pattern, err)
+		}
+		compiled.match = re.MatchString
+	case strings.HasPrefix(pattern, "prefix:"):
+		prefix := strings.ToLower(strings.TrimPrefix(pattern, "prefix:"))
+		compiled.match = func(name string) bool { return strings.HasPrefix(name, prefix) }
+	case strings.ContainsAny(pattern, "*?["):
+		glob := strings.ToLower(pattern)
+		if _, err := path.Match(glob, ""); err != nil {
+			return compiledMaskRule{}, fmt.Errorf("glob invalide %q: %w", pattern, err)
+		}
+		compiled.match = func(name string) bool {
+			ok, _ := path.Match(glob, name)
+			return ok
+		}
+	default:
+		exact := strings.ToLower(pattern)
+		compiled.match = func(name string) bool { return name == exact }
+	}
+	return compiled, nil
+}
+
+// Match - Retourner le mode de masquage applicable à un nom d'en-tête
+func (m *headerMasker) Match(name string) (string, bool) {
+	name = strings.ToLower(name)
+	for _, rule := range m.rules {
+		if rule.match(name) {
+			return rule.mode, true
+		}
+	}
+	return "", false
+}
+
+// Mask - Masquer une valeur selon le mode donné
+func (m *headerMasker) Mask(mode, value string) string {
+	switch mode {
+	case MaskModePartial:
+		// Trop courte, la fin révélerait une part significative de la valeur
+		// (découpage par caractère pour ne pas couper un caractère multi-octets)
+		runes := []rune(value)
+		if len(runes) <= 8 {
+			return maskedValue
+		}
+		return maskedValue + string(runes[len(runes)-4:])
+	case MaskModeHash:
+		mac := hmac.New(sha256.New, m.hashKey)
+		mac.Write([]byte(value))
+		return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))[:32]
+	default:
+		return maskedValue
+	}
+}
+
+// MaskQuery - Masquer les paramètres sélectionnés d'une chaîne "a=1&b=2" en conservant l'ordre
+func (m *headerMasker) MaskQuery(query string) string {
+	if len(m.rules) == 0 || query == "" {
+		return query
+	}
+
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		key, value, hasValue := strings.Cut(pair, "=")
+		if !hasValue {
+			continue
+		}
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if mode, ok := m.Match(name); ok {
+			if decoded, err := url.QueryUnescape(value); err == nil {
+				value = decoded
+			}
+			// Les caractères du masque ("*", ":") sont autorisés tels quels dans une requête
+			pairs[i] = key + "=" + m.Mask(mode, value)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// MaskCookies - Masquer les cookies sélectionnés d'un en-tête Cookie ou Set-Cookie
+//
+// Pour Set-Cookie, seule la première paire est un cookie, la suite étant des
+// attributs (Path, Expires...) laissés intacts.
+func (m *headerMasker) MaskCookies(value string, setCookie bool) string {
+	if len(m.rules) == 0 || value == "" {
+		return value
+	}
+
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		if setCookie && i > 0 {
+			break
+		}
+		name, cookieValue, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		if mode, ok := m.Match(name); ok {
+			parts[i] = name + "=" + m.Mask(mode, cookieValue)
+			if i > 0 {
+				parts[i] = " " + parts[i]
+			}
+		}
+	}
+	return strings.Join(parts, ";")
+}
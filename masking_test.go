@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMaskHeadersHidesSensitiveValues vérifie que la valeur réelle n'écrase plus le masque
+func TestMaskHeadersHidesSensitiveValues(t *testing.T) {
+	handler, _ := newTestHandler(Config{MaskHeaders: []string{"authorization"}})
+	defer handler.Close()
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer secret-token")
+	header.Set("Accept", "application/json")
+
+	headers := handler.rules.Load().maskHeaders(header)
+	assert.Equal(t, "********", headers["Authorization"], "L'en-tête Authorization doit être masqué")
+	assert.Equal(t, "application/json", headers["Accept"], "Les autres en-têtes doivent rester intacts")
+}
+
+// TestMaskRulePatterns vérifie les différents types de motifs
+func TestMaskRulePatterns(t *testing.T) {
+	masker, err := newHeaderMasker([]MaskRule{
+		parseMaskRule("x-*-secret"),
+		parseMaskRule("*token*"),
+		parseMaskRule("prefix:x-internal-"),
+		parseMaskRule("re:^x-api-(key|id)$"),
+	}, MaskModeRedact, "")
+	assert.NoError(t, err)
+
+	for _, name := range []string{"X-Client-Secret", "X-Auth-Token", "Refresh-Token-Id", "X-Internal-Trace", "X-Api-Key"} {
+		_, ok := masker.Match(name)
+		assert.True(t, ok, "L'en-tête %s doit être masqué", name)
+	}
+	for _, name := range []string{"X-Secret", "Accept", "X-Api-Keys"} {
+		_, ok := masker.Match(name)
+		assert.False(t, ok, "L'en-tête %s ne doit pas être masqué", name)
+	}
+}
+
+// TestMaskModes vérifie le masquage complet, partiel et par empreinte
+func TestMaskModes(t *testing.T) {
+	masker, err := newHeaderMasker([]MaskRule{
+		parseMaskRule("authorization=partial"),
+		parseMaskRule("x-api-key=hash"),
+		{Pattern: "cookie"},
+	}, MaskModeRedact, "cle-de-test")
+	assert.NoError(t, err)
+
+	mode, _ := masker.Match("Authorization")
+	assert.Equal(t, "********7890", masker.Mask(mode, "Bearer 1234567890"), "Seuls les 4 derniers caractères doivent être visibles")
+	assert.Equal(t, "********", masker.Mask(mode, "court"), "Une valeur courte doit être entièrement masquée")
+	assert.Equal(t, "********café", masker.Mask(mode, "identifiant-café"), "Le découpage doit respecter les caractères multi-octets")
+	assert.Equal(t, "********", masker.Mask(mode, "éléments"), "La longueur s'évalue en caractères")
+
+	mode, _ = masker.Match("X-Api-Key")
+	first := masker.Mask(mode, "abc")
+	assert.True(t, strings.HasPrefix(first, "hmac-sha256:"))
+	assert.Equal(t, first, masker.Mask(mode, "abc"), "L'empreinte doit être stable pour corréler les valeurs")
+	assert.NotEqual(t, first, masker.Mask(mode, "abd"))
+
+	mode, _ = masker.Match("Cookie")
+	assert.Equal(t, MaskModeRedact, mode, "Le mode par défaut doit s'appliquer")
+}
+
+// TestMaskRuleInvalidIsReported vérifie qu'une règle invalide est signalée sans bloquer les autres
+func TestMaskRuleInvalidIsReported(t *testing.T) {
+	masker, err := newHeaderMasker([]MaskRule{{Pattern: "re:("}, {Pattern: "authorization"}}, "", "")
+	assert.Error(t, err, "Une expression régulière invalide doit être signalée")
+
+	_, ok := masker.Match("Authorization")
+	assert.True(t, ok, "Les règles valides doivent rester actives")
+}
+
+// TestLogURLMasksQueryParams vérifie que seuls les paramètres sensibles de l'URL sont masqués
+func TestLogURLMasksQueryParams(t *testing.T) {
+	handler, _ := newTestHandler(Config{MaskQueryParams: []string{"access_token", "*key*=partial"}})
+	defer handler.Close()
+
+	u, _ := url.Parse("http://example.com/api?page=2&access_token=abc123&x-api-key=0123456789abcdef")
+	assert.Equal(t, "http://example.com/api?page=2&access_token=********&x-api-key=********cdef",
+		handler.rules.Load().logURL(u, map[string]int{}), "Les paramètres sensibles doivent être masqués sans modifier l'ordre")
+	assert.Equal(t, "abc123", u.Query().Get("access_token"), "L'URL transmise au serveur ne doit pas être modifiée")
+}
+
+// TestMaskHeadersMasksIndividualCookies vérifie le masquage cookie par cookie
+func TestMaskHeadersMasksIndividualCookies(t *testing.T) {
+	handler, _ := newTestHandler(Config{
+		MaskHeaders: []string{"authorization"},
+		MaskCookies: []string{"session*"},
+	})
+	defer handler.Close()
+
+	header := make(http.Header)
+	header.Set("Cookie", "theme=dark; sessionid=abc123; lang=fr")
+	header.Add("Set-Cookie", "sessionid=xyz; Path=/; HttpOnly")
+	header.Add("Set-Cookie", "theme=light; Path=/")
+
+	headers := handler.rules.Load().maskHeaders(header)
+	assert.Equal(t, "theme=dark; sessionid=********; lang=fr", headers["Cookie"])
+	assert.Equal(t, "sessionid=********; Path=/; HttpOnly, theme=light; Path=/", headers["Set-Cookie"],
+		"Les attributs et les cookies non sensibles doivent rester lisibles")
+}
+
+// TestDefaultConfigMasksSessionCookies vérifie que la configuration par défaut masque les cookies de session
+func TestDefaultConfigMasksSessionCookies(t *testing.T) {
+	handler, _ := newTestHandler(defaultConfig())
+	defer handler.Close()
+
+	header := make(http.Header)
+	header.Set("Cookie", "theme=dark; JSESSIONID=abc123; auth_token=xyz")
+	header.Add("Set-Cookie", "PHPSESSID=def; Path=/; HttpOnly")
+
+	headers := handler.rules.Load().maskHeaders(header)
+	assert.Equal(t, "theme=dark; JSESSIONID=********; auth_token=********", headers["Cookie"])
+	assert.Equal(t, "PHPSESSID=********; Path=/; HttpOnly", headers["Set-Cookie"],
+		"Set-Cookie ne doit plus être masqué en entier par défaut")
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// metrics - Indicateurs de fonctionnement exposés sur /debug/vars
+var metrics = expvar.NewMap("mitm_proxy")
+
+// startMetricsServer - Exposer les indicateurs expvar sur le port donné
+func startMetricsServer(port int) {
+	addr := fmt.Sprintf(":%d", port)
+	go func() {
+		// expvar enregistre /debug/vars sur le mux par défaut
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("Erreur du serveur de métriques: %v", err)
+		}
+	}()
+	fmt.Printf("Métriques disponibles sur http://localhost:%d/debug/vars\n", port)
+}
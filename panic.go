@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// recoverPanic - Intercepter une panique dans un hook et la journaliser
+//
+// A différer en tête de chaque hook: le proxy continue de servir et une
+// entrée critique, rattachée au flux quand c'est possible, est envoyée avec
+// la pile d'appels. pending désigne l'entrée déjà retirée du flowStore par
+// le hook, le cas échéant. Une entrée présente dans le flowStore a déjà été
+// envoyée en création (voir Request): elle est mise à jour, jamais recréée.
+func (h *MITMHandler) recoverPanic(hook string, f *proxy.Flow, pending **LogModel) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	h.panics.Add(1)
+	stack := string(debug.Stack())
+	log.Printf("Panique dans le hook %s: %v\n%s", hook, r, stack)
+
+	// Finaliser l'entrée en attente du flux, sinon en créer une nouvelle
+	action := "update"
+	var logEntry *LogModel
+	if pending != nil {
+		logEntry = *pending
+	}
+	if logEntry == nil && f != nil {
+		logEntry, _, _ = h.flows.Take(f.Id.String())
+	}
+	if logEntry == nil {
+		action = "create"
+		logEntry = h.panicLogEntry(f)
+	}
+
+	logEntry.HTTPReturnCode = http.StatusInternalServerError
+	logEntry.ExecutionTime = time.Since(logEntry.OccuredTime).Milliseconds()
+	logEntry.LogTextShort = "Panique"
+	logEntry.LogText = fmt.Sprintf("Panique dans le hook %s: %v", hook, r)
+	logEntry.LogType = "critical"
+	logEntry.Stacktrace = stack
+
+	if action == "create" {
+		h.queueLog(logEntry, action)
+		return
+	}
+	h.queueFinal(logEntry)
+}
+
+// panicLogEntry - Construire une entrée minimale à partir de ce qui reste lisible du flux
+func (h *MITMHandler) panicLogEntry(f *proxy.Flow) *LogModel {
+	requestID := uuid.New().String()
+	logEntry := &LogModel{
+		ID:            requestID,
+		CorrelationID: requestID,
+		ClientName:    "Anonyme",
+		User:          "Anonyme",
+		OccuredTime:   time.Now(),
+	}
+	if f == nil || f.Request == nil {
+		return logEntry
+	}
+
+	logEntry.HTTPMethod = f.Request.Method
+	if identity, ok := h.safeIdentity(f.Request); ok {
+		if identity.CorrelationID != "" {
+			logEntry.CorrelationID = identity.CorrelationID
+		}
+		if identity.Client != "" {
+			logEntry.ClientName = identity.Client
+		}
+	}
+	if f.Request.URL != nil {
+		// Sans masquage possible à ce stade, ne pas journaliser la requête
+		masked := *f.Request.URL
+		masked.RawQuery = ""
+		logEntry.HTTPUrl = masked.String()
+	}
+	return logEntry
+}
+
+// safeIdentity - Extraire l'identité d'une requête sans risquer une nouvelle panique
+func (h *MITMHandler) safeIdentity(req *proxy.Request) (identity requestIdentity, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return h.identity.extract(req), true
+}
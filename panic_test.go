@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPanicInResponseIsRecovered vérifie qu'une panique dans Response est journalisée sans arrêter le proxy
+func TestPanicInResponseIsRecovered(t *testing.T) {
+	handler, sink := newTestHandler(Config{})
+
+	f := newTestFlow("GET", "http://example.com/api/resource", nil)
+	f.Request.Header.Set("correlation-id", "corr-42")
+	handler.Request(f)
+
+	// f.Response est nil: Response déréférence un pointeur nil
+	assert.NotPanics(t, func() { handler.Response(f) }, "La panique doit être interceptée")
+	handler.Close()
+
+	updates := sink.entries(t, "update")
+	assert.Len(t, updates, 1)
+	assert.Equal(t, "critical", updates[0].LogType)
+	assert.Equal(t, "corr-42", updates[0].CorrelationID, "L'entrée doit être rattachée au flux")
+	assert.Equal(t, http.StatusInternalServerError, updates[0].HTTPReturnCode)
+	assert.Contains(t, updates[0].Stacktrace, "recoverPanic", "La pile d'appels doit être journalisée")
+	assert.Equal(t, int64(1), handler.panics.Load())
+}
+
+// TestPanicWithoutPendingFlowCreatesEntry vérifie qu'une panique hors flux en attente crée une entrée critique
+func TestPanicWithoutPendingFlowCreatesEntry(t *testing.T) {
+	handler, sink := newTestHandler(Config{})
+
+	f := newTestFlow("GET", "http://example.com/api/resource?token=secret", nil)
+	f.Request.Header = nil
+	f.Request.URL = nil
+
+	assert.NotPanics(t, func() { handler.Request(f) })
+	handler.Close()
+
+	creates := sink.entries(t, "create")
+	assert.Len(t, creates, 1)
+	assert.Equal(t, "critical", creates[0].LogType)
+	assert.Equal(t, "GET", creates[0].HTTPMethod)
+	assert.Empty(t, creates[0].HTTPUrl)
+}
+
+// TestPanicAfterCreateUpdatesSameEntry vérifie qu'une panique après l'envoi de la création met à jour la même entrée
+func TestPanicAfterCreateUpdatesSameEntry(t *testing.T) {
+	handler, _ := newTestHandler(Config{})
+
+	// File pleine dont le débordement enregistre l'entrée puis panique une fois,
+	// juste après la mise en file de la création
+	var jobs []logJob
+	queue, release, _, _ := blockedQueue(1, OverflowSpill, func(job logJob) bool {
+		jobs = append(jobs, job)
+		if len(jobs) == 1 {
+			panic("échec simulé après la création")
+		}
+		return true
+	})
+	queue.Push(logJob{action: "create", data: []byte("plein")})
+	handler.queue.Close()
+	handler.queue = queue
+
+	f := newTestFlow("GET", "http://example.com/api/resource", nil)
+	assert.NotPanics(t, func() { handler.Request(f) }, "La panique doit être interceptée")
+	close(release)
+	handler.Close()
+
+	if assert.Len(t, jobs, 2) {
+		var created, updated LogModel
+		assert.NoError(t, json.Unmarshal(jobs[0].data, &created))
+		assert.NoError(t, json.Unmarshal(jobs[1].data, &updated))
+		assert.Equal(t, "create", jobs[0].action)
+		assert.Equal(t, "update", jobs[1].action, "La panique doit finaliser l'entrée créée, pas en créer une seconde")
+		assert.Equal(t, created.ID, updated.ID)
+		assert.Equal(t, "critical", updated.LogType)
+	}
+	assert.Equal(t, 0, handler.flows.Len())
+}
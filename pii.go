@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Détecteurs de données personnelles disponibles (RGPD)
+const (
+	PIIEmail = "email"
+	PIIPhone = "phone" // numéros de téléphone français
+	PIIIBAN  = "iban"
+	PIICard  = "card" // numéros de carte bancaire (contrôle de Luhn)
+	PIINIR   = "nir"  // numéro de sécurité sociale
+	PIIIPv4  = "ipv4"
+	PIIIPv6  = "ipv6"
+)
+
+// piiDetector - Motif de détection et validation éventuelle d'une donnée personnelle
+type piiDetector struct {
+	name        string
+	placeholder string
+	re          *regexp.Regexp
+	valid       func(match string) bool
+}
+
+// piiDetectors - Détecteurs dans leur ordre d'application
+//
+// Les formats les plus spécifiques passent en premier pour qu'un IBAN ou un
+// NIR ne soit pas pris pour un numéro de carte ou de téléphone.
+var piiDetectors = []piiDetector{
+	{
+		name:        PIIEmail,
+		placeholder: "<EMAIL>",
+		re:          regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	},
+	{
+		name:        PIIIBAN,
+		placeholder: "<IBAN>",
+		re:          regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`),
+		valid:       validIBAN,
+	},
+	{
+		name:        PIINIR,
+		placeholder: "<NIR>",
+		re:          regexp.MustCompile(`\b[12] ?\d{2} ?(?:0[1-9]|1[0-2]|[2-9]\d) ?(?:\d{2}|2A|2B) ?\d{3} ?\d{3} ?\d{2}\b`),
+		valid:       validNIR,
+	},
+	{
+		name:        PIICard,
+		placeholder: "<CARD>",
+		re:          regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+		valid:       validLuhn,
+	},
+	{
+		name:        PIIPhone,
+		placeholder: "<PHONE>",
+		re:          regexp.MustCompile(`(?:(?:\+|\b00)33 ?\(?0?\)? ?|\b0)[1-9](?:[ .\-]?\d{2}){4}\b`),
+	},
+	{
+		name:        PIIIPv6,
+		placeholder: "<IP>",
+		re:          regexp.MustCompile(`(?i)(?:[0-9a-f]{1,4}|:)(?::[0-9a-f]{0,4}){2,7}`),
+		valid:       func(match string) bool { return net.ParseIP(match) != nil },
+	},
+	{
+		name:        PIIIPv4,
+		placeholder: "<IP>",
+		re:          regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`),
+	},
+}
+
+// piiScanner - Remplace les données personnelles par des marqueurs typés
+type piiScanner struct {
+	detectors []piiDetector
+}
+
+// newPIIScanner - Créer un scanner avec les détecteurs activés (nil si aucun)
+func newPIIScanner(enabled []string) (*piiScanner, error) {
+	wanted := make(map[string]bool)
+	for _, name := range enabled {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		// "ip" active les deux familles d'adresses
+		if name == "ip" {
+			wanted[PIIIPv4] = true
+			wanted[PIIIPv6] = true
+			continue
+		}
+		wanted[name] = true
+	}
+
+	s := &piiScanner{}
+	for _, detector := range piiDetectors {
+		if wanted[detector.name] {
+			s.detectors = append(s.detectors, detector)
+			delete(wanted, detector.name)
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("détecteur de données personnelles inconnu: %q", name)
+	}
+	if len(s.detectors) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// ScanText - Remplacer les données détectées dans un texte libre
+func (s *piiScanner) ScanText(text string, counts map[string]int) string {
+	for _, detector := range s.detectors {
+		text = detector.re.ReplaceAllStringFunc(text, func(match string) string {
+			if detector.valid != nil && !detector.valid(match) {
+				return match
+			}
+			counts[detector.name]++
+			return detector.placeholder
+		})
+	}
+	return text
+}
+
+// ScanBody - Remplacer les données détectées dans un corps de requête ou de réponse
+//
+// Les corps JSON sont parcourus valeur par valeur pour rester valides, les
+// formulaires champ par champ; les corps binaires sont laissés intacts.
+func (s *piiScanner) ScanBody(contentType string, body []byte, counts map[string]int) []byte {
+	if len(body) == 0 || !utf8.Valid(body) {
+		return body
+	}
+
+	if strings.Contains(strings.ToLower(contentType), "application/x-www-form-urlencoded") {
+		return []byte(s.scanQuery(string(body), counts))
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.UseNumber()
+		var doc interface{}
+		if err := decoder.Decode(&doc); err == nil {
+			before := sumCounts(counts)
+			doc = s.scanJSONValue(doc, counts)
+			if sumCounts(counts) == before {
+				return body
+			}
+			if redacted, err := json.Marshal(doc); err == nil {
+				return redacted
+			}
+			return body
+		}
+	}
+
+	return []byte(s.ScanText(string(body), counts))
+}
+
+// ScanURL - Remplacer les données détectées dans les paramètres d'une URL
+func (s *piiScanner) ScanURL(u *url.URL, counts map[string]int) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	redacted := *u
+	redacted.RawQuery = s.scanQuery(u.RawQuery, counts)
+	return redacted.String()
+}
+
+// scanQuery - Analyser chaque valeur d'une chaîne encodée "a=1&b=2" en conservant l'ordre
+func (s *piiScanner) scanQuery(query string, counts map[string]int) string {
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		key, value, hasValue := strings.Cut(pair, "=")
+		if !hasValue {
+			continue
+		}
+		decoded, err := url.QueryUnescape(value)
+		if err != nil {
+			continue
+		}
+		if scanned := s.ScanText(decoded, counts); scanned != decoded {
+			pairs[i] = key + "=" + url.QueryEscape(scanned)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// scanJSONValue - Analyser les chaînes et nombres d'un document JSON
+func (s *piiScanner) scanJSONValue(value interface{}, counts map[string]int) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = s.scanJSONValue(child, counts)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = s.scanJSONValue(child, counts)
+		}
+	case string:
+		return s.ScanText(v, counts)
+	case json.Number:
+		if scanned := s.ScanText(v.String(), counts); scanned != v.String() {
+			return scanned
+		}
+	}
+	return value
+}
+
+func sumCounts(counts map[string]int) int {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return total
+}
+
+// validLuhn - Vérifier la clé de Luhn d'un numéro de carte
+func validLuhn(match string) bool {
+	digits := onlyDigits(match)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// validIBAN - Vérifier la clé modulo 97 d'un IBAN
+func validIBAN(match string) bool {
+	iban := strings.ReplaceAll(match, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+
+	// Déplacer les 4 premiers caractères à la fin et convertir les lettres en nombres
+	rearranged := iban[4:] + iban[:4]
+	var numeric strings.Builder
+	for _, r := range rearranged {
+		switch {
+		case r >= '0' && r <= '9':
+			numeric.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			numeric.WriteString(fmt.Sprint(int(r-'A') + 10))
+		default:
+			return false
+		}
+	}
+
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// validNIR - Vérifier la clé d'un numéro de sécurité sociale (Corse: 2A=19, 2B=18)
+func validNIR(match string) bool {
+	nir := strings.ReplaceAll(match, " ", "")
+	if len(nir) != 15 {
+		return false
+	}
+	body, key := nir[:13], nir[13:]
+	body = strings.Replace(strings.Replace(body, "2A", "19", 1), "2B", "18", 1)
+
+	n, ok := new(big.Int).SetString(body, 10)
+	if !ok {
+		return false
+	}
+	var k int64
+	if _, err := fmt.Sscanf(key, "%d", &k); err != nil {
+		return false
+	}
+	return 97-new(big.Int).Mod(n, big.NewInt(97)).Int64() == k
+}
+
+func onlyDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPIIScannerText vérifie la détection de chaque type de donnée personnelle
+func TestPIIScannerText(t *testing.T) {
+	scanner, err := newPIIScanner([]string{"email", "phone", "iban", "card", "nir", "ip"})
+	assert.NoError(t, err)
+
+	cases := map[string]string{
+		"contact: jean.dupont@example.fr":                "contact: <EMAIL>",
+		"tél 06 12 34 56 78 ou +33 1 23 45 67 89":        "tél <PHONE> ou <PHONE>",
+		"iban FR76 3000 6000 0112 3456 7890 189":         "iban <IBAN>",
+		"carte 4111 1111 1111 1111":                      "carte <CARD>",
+		"nir 1 85 05 78 006 084 91":                      "nir <NIR>",
+		"depuis 192.168.1.10 et 2001:db8::8a2e:370:7334": "depuis <IP> et <IP>",
+	}
+	for input, expected := range cases {
+		counts := make(map[string]int)
+		assert.Equal(t, expected, scanner.ScanText(input, counts), "Entrée: %s", input)
+	}
+}
+
+// TestPIIScannerValidation vérifie que les numéros dont la clé est invalide sont conservés
+func TestPIIScannerValidation(t *testing.T) {
+	scanner, err := newPIIScanner([]string{"card", "iban"})
+	assert.NoError(t, err)
+
+	counts := make(map[string]int)
+	assert.Equal(t, "commande 4111 1111 1111 1112", scanner.ScanText("commande 4111 1111 1111 1112", counts),
+		"Un numéro qui ne respecte pas Luhn ne doit pas être masqué")
+	assert.Empty(t, counts)
+}
+
+// TestPIIScannerBodiesAndQuery vérifie l'analyse des corps JSON, des formulaires et de l'URL
+func TestPIIScannerBodiesAndQuery(t *testing.T) {
+	scanner, err := newPIIScanner([]string{"email", "card"})
+	assert.NoError(t, err)
+	counts := make(map[string]int)
+
+	json := scanner.ScanBody("application/json", []byte(`{"email":"a@b.fr","card":4111111111111111,"id":12}`), counts)
+	assert.JSONEq(t, `{"email":"<EMAIL>","card":"<CARD>","id":12}`, string(json), "Le JSON doit rester valide")
+
+	form := scanner.ScanBody("application/x-www-form-urlencoded", []byte("user=jdoe&mail=a%40b.fr"), counts)
+	assert.Equal(t, "user=jdoe&mail=%3CEMAIL%3E", string(form))
+
+	u, _ := url.Parse("http://example.com/search?q=test&email=c@d.fr")
+	assert.Equal(t, "http://example.com/search?q=test&email=%3CEMAIL%3E", scanner.ScanURL(u, counts))
+
+	assert.Equal(t, map[string]int{"email": 3, "card": 1}, counts, "Les remplacements doivent être comptés par type")
+}
+
+// TestPIIScannerUnknownDetector vérifie qu'un détecteur inconnu est signalé
+func TestPIIScannerUnknownDetector(t *testing.T) {
+	_, err := newPIIScanner([]string{"email", "passport"})
+	assert.Error(t, err)
+
+	scanner, err := newPIIScanner([]string{""})
+	assert.NoError(t, err)
+	assert.Nil(t, scanner, "Aucun détecteur activé ne doit désactiver l'analyse")
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// En-têtes de l'authentification auprès du proxy
+const (
+	proxyAuthorizationHeader = "Proxy-Authorization"
+	proxyAuthenticateHeader  = "Proxy-Authenticate"
+)
+
+// Limites de l'authentification au proxy: bcrypt est volontairement coûteux
+const (
+	proxyAuthCacheTTL      = 5 * time.Minute // durée de validité d'un en-tête déjà vérifié
+	proxyAuthCacheSize     = 4096            // en-têtes vérifiés et adresses suivies au plus
+	proxyAuthMaxFailures   = 10              // échecs de vérification tolérés par adresse et par fenêtre
+	proxyAuthFailureWindow = time.Minute
+)
+
+// proxyAuthRequiredBody - Corps des réponses 407
+const proxyAuthRequiredBody = "Authentification au proxy requise\n"
+
+// errProxyAuthRequired - Tunnel CONNECT refusé faute d'authentification
+var errProxyAuthRequired = errors.New("authentification au proxy requise")
+
+// proxyIdentity - Identité d'un client authentifié auprès du proxy
+type proxyIdentity struct {
+	User   string
+	Client string
+}
+
+// proxyCredential - Entrée du fichier d'identifiants
+type proxyCredential struct {
+	hash   []byte
+	client string
+}
+
+// proxyAuthResult - Résultat mis en cache de la vérification d'un en-tête
+type proxyAuthResult struct {
+	identity proxyIdentity
+	ok       bool
+	expires  time.Time
+}
+
+// proxyAuthFailures - Échecs de vérification d'une adresse dans la fenêtre courante
+type proxyAuthFailures struct {
+	count int
+	start time.Time
+}
+
+// proxyAuthenticator - Authentification "Proxy-Authorization" des clients du proxy
+//
+// Les clients s'authentifient en Basic avec un utilisateur du fichier
+// d'identifiants (mots de passe bcrypt) ou avec un jeton statique, en Basic
+// comme mot de passe ou en Bearer. L'identité est retenue pour la connexion:
+// les requêtes d'un tunnel CONNECT n'ont pas à la répéter.
+//
+// Les résultats, succès comme échecs, sont retenus pour un temps limité, et
+// une adresse qui multiplie les échecs n'obtient plus de vérification bcrypt
+// jusqu'à la fin de la fenêtre.
+type proxyAuthenticator struct {
+	realm  string
+	users  map[string]proxyCredential
+	tokens map[[sha256.Size]byte]proxyIdentity // par empreinte du jeton
+
+	mu       sync.Mutex
+	results  map[[sha256.Size]byte]proxyAuthResult // par empreinte de l'en-tête
+	attempts map[string]*proxyAuthFailures         // par adresse du client
+	conns    map[*proxy.ClientConn]proxyIdentity
+	refused  map[*http.Request]*proxy.ClientConn // CONNECT refusés, en attente de la connexion amont
+
+	failures atomic.Int64 // requêtes refusées
+}
+
+// newProxyAuthenticator - Charger les identifiants et jetons de la configuration
+//
+// Retourne nil si l'authentification n'est pas configurée. Le fichier est
+// vérifié par validateConfig; s'il devient illisible, le proxy ne s'ouvre
+// pas pour autant: les clients sans identifiants valides restent refusés.
+func newProxyAuthenticator(c Config) (*proxyAuthenticator, error) {
+	if c.ProxyAuthFile == "" && len(c.ProxyAuthTokens) == 0 {
+		return nil, nil
+	}
+	a := &proxyAuthenticator{
+		realm:    c.ProxyAuthRealm,
+		users:    make(map[string]proxyCredential),
+		tokens:   make(map[[sha256.Size]byte]proxyIdentity),
+		results:  make(map[[sha256.Size]byte]proxyAuthResult),
+		attempts: make(map[string]*proxyAuthFailures),
+		conns:    make(map[*proxy.ClientConn]proxyIdentity),
+		refused:  make(map[*http.Request]*proxy.ClientConn),
+	}
+
+	var errs []error
+	if c.ProxyAuthFile != "" {
+		if err := a.loadCredentials(c.ProxyAuthFile); err != nil {
+			errs = append(errs, fmt.Errorf("fichier d'identifiants %s: %w", c.ProxyAuthFile, err))
+		}
+	}
+	for i, spec := range c.ProxyAuthTokens {
+		client, token, ok := strings.Cut(strings.TrimSpace(spec), ":")
+		if !ok || client == "" || token == "" {
+			errs = append(errs, fmt.Errorf("jeton %d: \"client:jeton\" attendu", i))
+			continue
+		}
+		a.tokens[sha256.Sum256([]byte(token))] = proxyIdentity{User: client, Client: client}
+	}
+	return a, errors.Join(errs...)
+}
+
+// loadCredentials - Lire un fichier de lignes "utilisateur:hash_bcrypt[:client]"
+//
+// Le format est celui de "htpasswd -B"; les lignes vides et les
+// commentaires (#) sont ignorés, les lignes invalides signalées.
+func (a *proxyAuthenticator) loadCredentials(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var errs []error
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			errs = append(errs, fmt.Errorf("ligne %d: \"utilisateur:hash[:client]\" attendu", n))
+			continue
+		}
+		if _, err := bcrypt.Cost([]byte(fields[1])); err != nil {
+			errs = append(errs, fmt.Errorf("ligne %d: hash bcrypt invalide", n))
+			continue
+		}
+		credential := proxyCredential{hash: []byte(fields[1]), client: fields[0]}
+		if len(fields) == 3 && fields[2] != "" {
+			credential.client = fields[2]
+		}
+		a.users[fields[0]] = credential
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// authenticate - Vérifier la valeur d'un en-tête Proxy-Authorization
+//
+// host est l'adresse du client: au-delà de proxyAuthMaxFailures échecs dans
+// la fenêtre, seuls les en-têtes déjà en cache sont encore vérifiés.
+func (a *proxyAuthenticator) authenticate(header, host string) (proxyIdentity, bool) {
+	key := sha256.Sum256([]byte(header))
+	now := time.Now()
+	a.mu.Lock()
+	result, cached := a.results[key]
+	throttled := a.throttled(host, now)
+	a.mu.Unlock()
+	if cached && now.Before(result.expires) {
+		return result.identity, result.ok
+	}
+	if throttled {
+		return proxyIdentity{}, false
+	}
+
+	identity, ok := a.verify(header)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.results) >= proxyAuthCacheSize {
+		for k, r := range a.results {
+			if now.After(r.expires) || len(a.results) >= proxyAuthCacheSize {
+				delete(a.results, k)
+			}
+		}
+	}
+	a.results[key] = proxyAuthResult{identity: identity, ok: ok, expires: now.Add(proxyAuthCacheTTL)}
+	if !ok {
+		a.recordFailure(host, now)
+	}
+	return identity, ok
+}
+
+// verify - Contrôler les identifiants d'un en-tête (bcrypt pour les utilisateurs du fichier)
+func (a *proxyAuthenticator) verify(header string) (proxyIdentity, bool) {
+	scheme, credentials, _ := strings.Cut(strings.TrimSpace(header), " ")
+	credentials = strings.TrimSpace(credentials)
+	switch {
+	case strings.EqualFold(scheme, "Basic"):
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return proxyIdentity{}, false
+		}
+		user, password, _ := strings.Cut(string(decoded), ":")
+		if credential, found := a.users[user]; found {
+			if bcrypt.CompareHashAndPassword(credential.hash, []byte(password)) == nil {
+				return proxyIdentity{User: user, Client: credential.client}, true
+			}
+		}
+		identity, ok := a.tokens[sha256.Sum256([]byte(password))]
+		return identity, ok
+	case strings.EqualFold(scheme, "Bearer"):
+		identity, ok := a.tokens[sha256.Sum256([]byte(credentials))]
+		return identity, ok
+	}
+	return proxyIdentity{}, false
+}
+
+// throttled - Indiquer si l'adresse a épuisé ses échecs dans la fenêtre (verrou tenu)
+func (a *proxyAuthenticator) throttled(host string, now time.Time) bool {
+	attempt, ok := a.attempts[host]
+	return ok && now.Sub(attempt.start) < proxyAuthFailureWindow && attempt.count >= proxyAuthMaxFailures
+}
+
+// recordFailure - Compter un échec de vérification pour l'adresse (verrou tenu)
+func (a *proxyAuthenticator) recordFailure(host string, now time.Time) {
+	attempt, ok := a.attempts[host]
+	if ok && now.Sub(attempt.start) < proxyAuthFailureWindow {
+		attempt.count++
+		return
+	}
+	if len(a.attempts) >= proxyAuthCacheSize {
+		for k, f := range a.attempts {
+			if now.Sub(f.start) >= proxyAuthFailureWindow || len(a.attempts) >= proxyAuthCacheSize {
+				delete(a.attempts, k)
+			}
+		}
+	}
+	a.attempts[host] = &proxyAuthFailures{count: 1, start: now}
+}
+
+// admit - Authentifier le flux et retirer l'en-tête avant sa transmission
+//
+// Un flux sans en-tête est admis si sa connexion a déjà été authentifiée
+// (requêtes d'un tunnel CONNECT). Un flux refusé reçoit une réponse 407
+// (voir reject pour les CONNECT).
+func (a *proxyAuthenticator) admit(f *proxy.Flow) bool {
+	header := f.Request.Header.Get(proxyAuthorizationHeader)
+	f.Request.Header.Del(proxyAuthorizationHeader)
+	client := clientConnOf(f)
+
+	if header != "" {
+		if identity, ok := a.authenticate(header, clientHost(client)); ok {
+			if client != nil {
+				a.mu.Lock()
+				a.conns[client] = identity
+				a.mu.Unlock()
+			}
+			return true
+		}
+	} else if _, ok := a.identityOf(f); ok {
+		return true
+	}
+
+	a.failures.Add(1)
+	log.Printf("Authentification au proxy refusée: %s %s", f.Request.Method, f.Request.URL.Host)
+	a.reject(f)
+	return false
+}
+
+// identityOf - Identité authentifiée de la connexion d'un flux
+func (a *proxyAuthenticator) identityOf(f *proxy.Flow) (proxyIdentity, bool) {
+	client := clientConnOf(f)
+	if client == nil {
+		return proxyIdentity{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	identity, ok := a.conns[client]
+	return identity, ok
+}
+
+// forget - Oublier l'identité et les CONNECT refusés d'une connexion fermée
+func (a *proxyAuthenticator) forget(client *proxy.ClientConn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.conns, client)
+	for req, conn := range a.refused {
+		if conn == client {
+			delete(a.refused, req)
+		}
+	}
+}
+
+// reject - Refuser le flux
+//
+// La réponse 407 fixée par Requestheaders est renvoyée au client, sauf pour
+// un CONNECT: go-mitmproxy n'en tient pas compte et ouvre le tunnel. Les
+// CONNECT sont donc refusés en amont par authGate; ceux qui l'atteignent
+// malgré tout (connexion directe à l'adresse locale de go-mitmproxy) sont
+// retenus pour que upstreamProxy fasse échouer la connexion au serveur amont.
+func (a *proxyAuthenticator) reject(f *proxy.Flow) {
+	if f.Request.Method == http.MethodConnect {
+		a.mu.Lock()
+		a.refused[f.Request.Raw()] = clientConnOf(f)
+		a.mu.Unlock()
+		return
+	}
+
+	f.Response = &proxy.Response{
+		StatusCode: http.StatusProxyAuthRequired,
+		Header:     a.challengeHeader(),
+		Body:       []byte(proxyAuthRequiredBody),
+	}
+}
+
+// challengeHeader - En-têtes d'une réponse 407 (défi Proxy-Authenticate)
+func (a *proxyAuthenticator) challengeHeader() http.Header {
+	header := make(http.Header)
+	header.Set(proxyAuthenticateHeader, fmt.Sprintf("Basic realm=%q", a.realm))
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	return header
+}
+
+// upstreamProxy - Envelopper le choix du proxy amont (Proxy.SetUpstreamProxy)
+//
+// La connexion échoue pour un CONNECT refusé par admit; sinon le choix est
+// délégué à next, qui doit reproduire celui de go-mitmproxy.
+func (a *proxyAuthenticator) upstreamProxy(next func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		a.mu.Lock()
+		_, refused := a.refused[req]
+		delete(a.refused, req)
+		a.mu.Unlock()
+		if refused {
+			return nil, errProxyAuthRequired
+		}
+		return next(req)
+	}
+}
+
+// libraryUpstreamProxy - Choix du proxy amont par défaut de go-mitmproxy
+//
+// Options.Upstream s'il est renseigné, sinon HTTPS_PROXY et NO_PROXY.
+func libraryUpstreamProxy(opts *proxy.Options) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if opts.Upstream != "" {
+			return url.Parse(opts.Upstream)
+		}
+		return http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: req.Host}})
+	}
+}
+
+// clientConnOf - Connexion client d'un flux (nil hors du proxy)
+func clientConnOf(f *proxy.Flow) *proxy.ClientConn {
+	if f.ConnContext == nil {
+		return nil
+	}
+	return f.ConnContext.ClientConn
+}
+
+// clientHost - Adresse IP d'un client, avant relais par authGate ("" si inconnue)
+func clientHost(client *proxy.ClientConn) string {
+	if client == nil || client.Conn == nil {
+		return ""
+	}
+	addr := clientAddress(client.Conn)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// basicAuth - Valeur d'un en-tête Proxy-Authorization Basic
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// writeCredentialsFile - Fichier d'identifiants avec les hash bcrypt des mots de passe donnés
+func writeCredentialsFile(t *testing.T, lines ...string) string {
+	content := ""
+	for _, line := range lines {
+		content += line + "\n"
+	}
+	return writeConfigFile(t, "htpasswd", content)
+}
+
+func bcryptHash(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	assert.NoError(t, err)
+	return string(hash)
+}
+
+// TestProxyAuthenticatorCredentials vérifie les identifiants du fichier et les jetons statiques
+func TestProxyAuthenticatorCredentials(t *testing.T) {
+	auth, err := newProxyAuthenticator(Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, auth, "L'authentification doit être désactivée sans identifiants")
+
+	path := writeCredentialsFile(t,
+		"# comptes du proxy",
+		"alice:"+bcryptHash(t, "motdepasse")+":portail",
+		"bob:"+bcryptHash(t, "secret"),
+		"ligne-invalide",
+		"carol:pas-un-hash",
+	)
+	auth, err = newProxyAuthenticator(Config{
+		ProxyAuthFile:   path,
+		ProxyAuthTokens: []string{"batch:jeton-batch", "sans-jeton"},
+	})
+	assert.Error(t, err, "Les lignes et jetons invalides doivent être signalés")
+	assert.NotNil(t, auth)
+
+	cases := []struct {
+		header   string
+		identity proxyIdentity
+		ok       bool
+	}{
+		{basicAuth("alice", "motdepasse"), proxyIdentity{User: "alice", Client: "portail"}, true},
+		{basicAuth("bob", "secret"), proxyIdentity{User: "bob", Client: "bob"}, true},
+		{basicAuth("alice", "mauvais"), proxyIdentity{}, false},
+		{basicAuth("carol", "pas-un-hash"), proxyIdentity{}, false},
+		{basicAuth("batch", "jeton-batch"), proxyIdentity{User: "batch", Client: "batch"}, true},
+		{basicAuth("", "jeton-batch"), proxyIdentity{User: "batch", Client: "batch"}, true},
+		{"Bearer jeton-batch", proxyIdentity{User: "batch", Client: "batch"}, true},
+		{"Bearer inconnu", proxyIdentity{}, false},
+		{"Basic !!!", proxyIdentity{}, false},
+		{"Digest username=alice", proxyIdentity{}, false},
+	}
+	for _, c := range cases {
+		identity, ok := auth.authenticate(c.header, "10.0.0.1")
+		assert.Equal(t, c.ok, ok, "Résultat inattendu pour %q", c.header)
+		assert.Equal(t, c.identity, identity)
+	}
+
+	// Une seconde vérification est servie par le cache
+	identity, ok := auth.authenticate(basicAuth("alice", "motdepasse"), "10.0.0.1")
+	assert.True(t, ok)
+	assert.Equal(t, "portail", identity.Client)
+}
+
+// TestProxyAuthRejectsAndIdentifiesClients vérifie le refus 407 et l'identité journalisée
+func TestProxyAuthRejectsAndIdentifiesClients(t *testing.T) {
+	path := writeCredentialsFile(t, "alice:"+bcryptHash(t, "motdepasse")+":portail")
+	handler, sink := newTestHandler(Config{ProxyAuthFile: path, ProxyAuthRealm: "test"})
+	client := &proxy.ClientConn{}
+
+	anonymous := newTestFlow("GET", "http://example.com/anonyme", nil)
+	anonymous.ConnContext = &proxy.ConnContext{ClientConn: client}
+	handler.Requestheaders(anonymous)
+	if assert.NotNil(t, anonymous.Response, "Un client non authentifié doit être refusé") {
+		assert.Equal(t, http.StatusProxyAuthRequired, anonymous.Response.StatusCode)
+		assert.Equal(t, `Basic realm="test"`, anonymous.Response.Header.Get("Proxy-Authenticate"))
+	}
+
+	authenticated := newTestFlow("GET", "http://example.com/authentifie", nil)
+	authenticated.ConnContext = &proxy.ConnContext{ClientConn: client}
+	authenticated.Request.Header.Set("Proxy-Authorization", basicAuth("alice", "motdepasse"))
+	authenticated.Request.Header.Set("client-name", "usurpateur")
+	authenticated.Request.Header.Set("username", "usurpateur")
+	handler.Requestheaders(authenticated)
+	assert.Nil(t, authenticated.Response)
+	assert.Empty(t, authenticated.Request.Header.Get("Proxy-Authorization"), "L'en-tête ne doit pas être transmis au serveur amont")
+	handler.Request(authenticated)
+
+	// Requête suivante de la même connexion (tunnel CONNECT): sans en-tête
+	tunneled := newTestFlow("GET", "https://example.com/tunnel", nil)
+	tunneled.ConnContext = &proxy.ConnContext{ClientConn: client}
+	handler.Requestheaders(tunneled)
+	assert.Nil(t, tunneled.Response, "La connexion authentifiée doit être retenue")
+	handler.Request(tunneled)
+
+	handler.ClientDisconnected(client)
+	closed := newTestFlow("GET", "http://example.com/ferme", nil)
+	closed.ConnContext = &proxy.ConnContext{ClientConn: client}
+	handler.Requestheaders(closed)
+	assert.NotNil(t, closed.Response, "L'identité doit être oubliée à la fermeture de la connexion")
+	assert.Equal(t, int64(2), handler.auth.failures.Load())
+	handler.Close()
+
+	entries := sink.entries(t, "create")
+	assert.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, "portail", entry.ClientName, "Le client authentifié doit primer sur l'en-tête")
+		assert.Equal(t, "alice", entry.User)
+		assert.True(t, entry.ProxyAuthenticated)
+		assert.NotContains(t, entry.HTTPHeaders, "Proxy-Authorization")
+	}
+}
+
+// TestProxyAuthRejectsConnect vérifie le défi 407 d'un CONNECT refusé, sans tunnel ni connexion amont
+func TestProxyAuthRejectsConnect(t *testing.T) {
+	// Serveur amont qui compte les connexions reçues
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer upstream.Close()
+	var accepted atomic.Int64
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			conn.Close()
+		}
+	}()
+
+	handler, sink := newTestHandler(Config{ProxyAuthTokens: []string{"batch:jeton-batch"}, LogConnections: true})
+	defer handler.Close()
+	next := func(*http.Request) (*url.URL, error) { return nil, nil }
+	backend := startTestProxy(t, func(p *proxy.Proxy) { p.SetUpstreamProxy(handler.auth.upstreamProxy(next)) }, handler)
+	gate, err := newAuthGate("127.0.0.1:0", backend, handler.auth)
+	assert.NoError(t, err)
+	defer gate.Close()
+
+	connect := func(conn net.Conn, reader *bufio.Reader, header string) *http.Response {
+		fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %[1]s\r\n%s\r\n", upstream.Addr(), header)
+		resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+		if !assert.NoError(t, err) {
+			return &http.Response{}
+		}
+		if resp.StatusCode != http.StatusOK {
+			// Le corps d'un tunnel établi n'a pas de fin: seul un refus est lu
+			io.Copy(io.Discard, resp.Body)
+		}
+		return resp
+	}
+
+	// Défi 407 puis nouvel essai authentifié sur la même connexion
+	conn, err := net.Dial("tcp", gate.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	resp := connect(conn, reader, "")
+	assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+	assert.Equal(t, `Basic realm="mitm-proxy"`, resp.Header.Get("Proxy-Authenticate"))
+	assert.Zero(t, accepted.Load(), "Le serveur amont ne doit pas être contacté")
+	assert.Equal(t, int64(1), handler.auth.failures.Load())
+
+	resp = connect(conn, reader, "Proxy-Authorization: Bearer jeton-batch\r\n")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Eventually(t, func() bool { return accepted.Load() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	// go-mitmproxy voit l'adresse du client, pas celle de la passerelle
+	assert.Eventually(t, func() bool {
+		for _, event := range sink.connectionEvents(t) {
+			if event.Event == ConnEventServerConnected {
+				return event.ClientAddress == conn.LocalAddr().String()
+			}
+		}
+		return false
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Un CONNECT adressé directement à go-mitmproxy échoue avant la connexion amont
+	direct, err := net.Dial("tcp", backend)
+	assert.NoError(t, err)
+	defer direct.Close()
+	resp = connect(direct, bufio.NewReader(direct), "")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, int64(1), accepted.Load())
+}
+
+// TestProxyAuthKeepsUpstreamProxy vérifie que le choix du proxy amont est délégué
+func TestProxyAuthKeepsUpstreamProxy(t *testing.T) {
+	auth, err := newProxyAuthenticator(Config{ProxyAuthTokens: []string{"batch:jeton-batch"}})
+	assert.NoError(t, err)
+
+	chained, _ := url.Parse("http://parent-proxy:3128")
+	resolve := auth.upstreamProxy(libraryUpstreamProxy(&proxy.Options{Upstream: chained.String()}))
+	proxyURL, err := resolve(&http.Request{Host: "example.com:443"})
+	assert.NoError(t, err)
+	assert.Equal(t, chained, proxyURL, "Le proxy amont configuré doit être conservé")
+}
+
+// TestProxyAuthLimitsFailures vérifie le cache des échecs et la limite par adresse
+func TestProxyAuthLimitsFailures(t *testing.T) {
+	path := writeCredentialsFile(t, "alice:"+bcryptHash(t, "motdepasse"))
+	auth, err := newProxyAuthenticator(Config{ProxyAuthFile: path})
+	assert.NoError(t, err)
+
+	// Un même en-tête invalide n'est vérifié qu'une fois
+	for i := 0; i < 3; i++ {
+		_, ok := auth.authenticate(basicAuth("alice", "mauvais"), "10.0.0.1")
+		assert.False(t, ok)
+	}
+	assert.Equal(t, 1, auth.attempts["10.0.0.1"].count, "Les échecs en cache ne doivent pas relancer bcrypt")
+
+	// Au-delà de la limite, même un mot de passe correct n'est plus vérifié
+	for i := 1; i < proxyAuthMaxFailures; i++ {
+		auth.authenticate(basicAuth("alice", fmt.Sprintf("essai-%d", i)), "10.0.0.1")
+	}
+	_, ok := auth.authenticate(basicAuth("alice", "motdepasse"), "10.0.0.1")
+	assert.False(t, ok, "L'adresse doit être limitée")
+	_, ok = auth.authenticate(basicAuth("alice", "motdepasse"), "10.0.0.2")
+	assert.True(t, ok, "Les autres adresses ne doivent pas être affectées")
+	_, ok = auth.authenticate(basicAuth("alice", "motdepasse"), "10.0.0.1")
+	assert.True(t, ok, "Un en-tête déjà vérifié reste accepté depuis le cache")
+
+	// La limite tombe avec la fenêtre
+	auth.attempts["10.0.0.1"].start = time.Now().Add(-proxyAuthFailureWindow)
+	_, ok = auth.authenticate(basicAuth("alice", "autre-essai"), "10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, 1, auth.attempts["10.0.0.1"].count, "Une nouvelle fenêtre doit commencer")
+}
+
+// TestProxyAuthCacheIsBounded vérifie l'expiration et la taille maximale du cache
+func TestProxyAuthCacheIsBounded(t *testing.T) {
+	auth, err := newProxyAuthenticator(Config{ProxyAuthTokens: []string{"batch:jeton-batch"}})
+	assert.NoError(t, err)
+
+	for i := 0; i < proxyAuthCacheSize+10; i++ {
+		auth.authenticate(fmt.Sprintf("Bearer inconnu-%d", i), fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+	assert.LessOrEqual(t, len(auth.results), proxyAuthCacheSize)
+	assert.LessOrEqual(t, len(auth.attempts), proxyAuthCacheSize)
+
+	_, ok := auth.authenticate("Bearer jeton-batch", "10.1.0.1")
+	assert.True(t, ok)
+	key := sha256.Sum256([]byte("Bearer jeton-batch"))
+	result := auth.results[key]
+	result.identity, result.expires = proxyIdentity{User: "périmé"}, time.Now().Add(-time.Second)
+	auth.results[key] = result
+	identity, ok := auth.authenticate("Bearer jeton-batch", "10.1.0.1")
+	assert.True(t, ok)
+	assert.Equal(t, "batch", identity.User, "Un résultat expiré doit être vérifié à nouveau")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ReloadRules - Remplacer atomiquement les règles de capture et de masquage
+//
+// Les flux en cours conservent les règles en vigueur à leur début. Si une
+// règle est invalide, le rechargement est refusé et les règles actuelles
+// restent en place.
+func (h *MITMHandler) ReloadRules(config Config) error {
+	current := h.rules.Load()
+	rc := rulesOf(config)
+	// Sans clé configurée, garder la clé éphémère pour que les empreintes restent comparables
+	if rc.MaskHashKey == "" {
+		rc.MaskHashKey = current.source.MaskHashKey
+	}
+
+	rules, err := compileRules(rc)
+	if err != nil {
+		return fmt.Errorf("rechargement refusé, règles invalides: %w", err)
+	}
+
+	changes := diffFields(current.source, rc, "MaskHashKey")
+	if len(changes) == 0 {
+		log.Printf("Rechargement: aucune règle modifiée")
+		return nil
+	}
+	h.rules.Store(rules)
+	log.Printf("Règles rechargées: %s", strings.Join(changes, "; "))
+	return nil
+}
+
+// diffFields - Décrire les champs qui diffèrent entre deux structures du même type
+//
+// Les champs sont nommés par leur clé du fichier de configuration; la valeur
+// des champs secrets n'est pas affichée.
+func diffFields(before, after interface{}, secrets ...string) []string {
+	configType := reflect.TypeOf(Config{})
+	b, a := reflect.ValueOf(before), reflect.ValueOf(after)
+
+	var changes []string
+	for i := 0; i < b.NumField(); i++ {
+		field := b.Type().Field(i)
+		if reflect.DeepEqual(b.Field(i).Interface(), a.Field(i).Interface()) {
+			continue
+		}
+
+		name := field.Name
+		if configField, ok := configType.FieldByName(field.Name); ok {
+			if tag := configField.Tag.Get("yaml"); tag != "" {
+				name = tag
+			}
+		}
+		if oneOf(field.Name, secrets...) {
+			changes = append(changes, name+" modifié")
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, b.Field(i).Interface(), a.Field(i).Interface()))
+	}
+	return changes
+}
+
+// configReloader - Recharge les règles sur SIGHUP et à la modification du fichier de configuration
+type configReloader struct {
+	path     string
+	interval time.Duration
+	handler  *MITMHandler
+	loaded   Config
+	modTime  time.Time
+	signals  chan os.Signal
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// startConfigReloader - Démarrer la surveillance du signal SIGHUP et du fichier (path peut être vide)
+func startConfigReloader(path string, config Config, handler *MITMHandler) *configReloader {
+	r := &configReloader{
+		path:     path,
+		interval: config.ConfigWatchInterval,
+		handler:  handler,
+		loaded:   config,
+		signals:  make(chan os.Signal, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if info, err := os.Stat(path); path != "" && err == nil {
+		r.modTime = info.ModTime()
+	}
+	signal.Notify(r.signals, syscall.SIGHUP)
+	go r.run()
+	return r
+}
+
+// Close - Arrêter la surveillance
+func (r *configReloader) Close() {
+	signal.Stop(r.signals)
+	close(r.stop)
+	<-r.done
+}
+
+// run - Attendre un signal ou une modification du fichier
+func (r *configReloader) run() {
+	defer close(r.done)
+
+	var tick <-chan time.Time
+	if r.path != "" && r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-r.signals:
+			r.reload("SIGHUP")
+		case <-tick:
+			info, err := os.Stat(r.path)
+			if err != nil || info.ModTime().Equal(r.modTime) {
+				continue
+			}
+			r.modTime = info.ModTime()
+			r.reload("modification de " + r.path)
+		}
+	}
+}
+
+// reload - Relire la configuration et appliquer les règles
+func (r *configReloader) reload(reason string) {
+	log.Printf("Rechargement de la configuration (%s)", reason)
+
+	config, err := loadConfig(r.path)
+	if err != nil {
+		log.Printf("Rechargement refusé, configuration invalide: %v", err)
+		return
+	}
+	if err := r.handler.ReloadRules(config); err != nil {
+		log.Print(err)
+		return
+	}
+
+	// Les autres paramètres (ports, sorties, file...) ne sont lus qu'au démarrage
+	before, after := r.loaded, config
+	clearRuleFields(&before)
+	clearRuleFields(&after)
+	if ignored := diffFields(before, after, "MaskHashKey", "JWTSecret", "ProxyAuthTokens", "LoggerEndpoint", "BatchEndpoint"); len(ignored) > 0 {
+		log.Printf("Modifications ignorées jusqu'au redémarrage: %s", strings.Join(ignored, "; "))
+	}
+	r.loaded = config
+}
+
+// clearRuleFields - Effacer les champs rechargeables d'une configuration
+func clearRuleFields(c *Config) {
+	c.ExcludedRoutes = nil
+	c.CaptureRules = nil
+	c.CaptureDefault = ""
+	c.MaskHeaders = nil
+	c.MaskRules = nil
+	c.MaskMode = ""
+	c.MaskHashKey = ""
+	c.MaskQueryParams = nil
+	c.MaskCookies = nil
+	c.BodyRedactRules = nil
+	c.PIIDetectors = nil
+	c.SkipBodyContentTypes = nil
+}
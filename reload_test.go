@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReloadRulesKeepsSnapshotForInFlightFlows vérifie qu'un flux en cours garde ses règles
+func TestReloadRulesKeepsSnapshotForInFlightFlows(t *testing.T) {
+	handler, sink := newTestHandler(Config{MaskHeaders: []string{"x-secret"}})
+
+	inFlight := newTestFlow("GET", "http://example.com/avant", nil)
+	handler.Request(inFlight)
+
+	assert.NoError(t, handler.ReloadRules(Config{ExcludedRoutes: []string{"/health"}}))
+
+	inFlight.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header)}
+	inFlight.Response.Header.Set("X-Secret", "valeur")
+	handler.Response(inFlight)
+
+	after := newTestFlow("GET", "http://example.com/apres", nil)
+	handler.Request(after)
+	after.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header)}
+	after.Response.Header.Set("X-Secret", "valeur")
+	handler.Response(after)
+
+	excluded := newTestFlow("GET", "http://example.com/health", nil)
+	handler.Request(excluded)
+	handler.Close()
+
+	assert.Len(t, sink.entries(t, "create"), 2, "La route exclue après rechargement ne doit pas être journalisée")
+	for _, entry := range sink.entries(t, "update") {
+		switch entry.HTTPUrl {
+		case "http://example.com/avant":
+			assert.Equal(t, "********", entry.HTTPResponseHeaders["X-Secret"], "Le flux en cours doit garder les règles de son début")
+		case "http://example.com/apres":
+			assert.Equal(t, "valeur", entry.HTTPResponseHeaders["X-Secret"], "Les nouveaux flux doivent utiliser les règles rechargées")
+		default:
+			t.Errorf("Entrée inattendue: %s", entry.HTTPUrl)
+		}
+	}
+}
+
+// TestReloadRulesRejectsInvalidRules vérifie que des règles invalides laissent les règles actuelles en place
+func TestReloadRulesRejectsInvalidRules(t *testing.T) {
+	handler, _ := newTestHandler(Config{MaskHeaders: []string{"authorization"}})
+	defer handler.Close()
+
+	before := handler.rules.Load()
+	err := handler.ReloadRules(Config{MaskRules: []MaskRule{{Pattern: "re:("}}})
+	assert.Error(t, err, "Une expression régulière invalide doit être refusée")
+	assert.Same(t, before, handler.rules.Load(), "Les règles actuelles doivent rester en place")
+}
+
+// TestReloadRulesKeepsEphemeralHashKey vérifie que les empreintes restent comparables après rechargement
+func TestReloadRulesKeepsEphemeralHashKey(t *testing.T) {
+	config := Config{MaskHeaders: []string{"x-api-key"}, MaskMode: MaskModeHash}
+	handler, _ := newTestHandler(config)
+	defer handler.Close()
+
+	header := make(http.Header)
+	header.Set("X-Api-Key", "abc")
+	first := handler.rules.Load().maskHeaders(header)["X-Api-Key"]
+
+	config.MaskCookies = []string{"session"}
+	assert.NoError(t, handler.ReloadRules(config))
+	assert.Equal(t, first, handler.rules.Load().maskHeaders(header)["X-Api-Key"], "La clé éphémère doit être conservée")
+}
+
+// TestDiffFields vérifie la description des modifications
+func TestDiffFields(t *testing.T) {
+	before := ruleConfig{ExcludedRoutes: []string{"/health"}, MaskHashKey: "a"}
+	after := ruleConfig{ExcludedRoutes: []string{"/health", "/metrics"}, MaskHashKey: "b"}
+
+	assert.Equal(t, []string{
+		"excluded_routes: [/health] -> [/health /metrics]",
+		"mask_hash_key modifié",
+	}, diffFields(before, after, "MaskHashKey"), "Les secrets ne doivent pas être affichés")
+	assert.Empty(t, diffFields(before, before))
+}
+
+// TestConfigReloaderWatchesFile vérifie le rechargement à la modification du fichier
+func TestConfigReloaderWatchesFile(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "excluded_routes: [/health]\n")
+	config, err := loadConfig(path)
+	assert.NoError(t, err)
+	config.ConfigWatchInterval = 10 * time.Millisecond
+
+	handler, _ := newTestHandler(config)
+	defer handler.Close()
+	reloader := startConfigReloader(path, config, handler)
+	defer reloader.Close()
+
+	assert.NoError(t, os.WriteFile(path, []byte("excluded_routes: [/health, /metrics]\n"), 0o600))
+	// Garantir un horodatage différent malgré la résolution du système de fichiers
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, future, future))
+
+	metrics := newTestFlow("GET", "http://example.com/metrics", nil).Request
+	assert.Eventually(t, func() bool {
+		return handler.rules.Load().captureAction(metrics, "Anonyme") == CaptureSkip
+	}, time.Second, 10*time.Millisecond, "La modification du fichier doit recharger les exclusions")
+}
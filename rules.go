@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// ruleConfig - Partie de la configuration rechargeable à chaud
+type ruleConfig struct {
+	ExcludedRoutes       []string
+	CaptureRules         []CaptureRule
+	CaptureDefault       string
+	MaskHeaders          []string
+	MaskRules            []MaskRule
+	MaskMode             string
+	MaskHashKey          string
+	MaskQueryParams      []string
+	MaskCookies          []string
+	BodyRedactRules      []BodyRedactRule
+	PIIDetectors         []string
+	SkipBodyContentTypes []string
+}
+
+// rulesOf - Extraire les règles d'une configuration
+func rulesOf(c Config) ruleConfig {
+	return ruleConfig{
+		ExcludedRoutes:       c.ExcludedRoutes,
+		CaptureRules:         c.CaptureRules,
+		CaptureDefault:       c.CaptureDefault,
+		MaskHeaders:          c.MaskHeaders,
+		MaskRules:            c.MaskRules,
+		MaskMode:             c.MaskMode,
+		MaskHashKey:          c.MaskHashKey,
+		MaskQueryParams:      c.MaskQueryParams,
+		MaskCookies:          c.MaskCookies,
+		BodyRedactRules:      c.BodyRedactRules,
+		PIIDetectors:         c.PIIDetectors,
+		SkipBodyContentTypes: c.SkipBodyContentTypes,
+	}
+}
+
+// ruleSet - Règles compilées de capture et de masquage
+//
+// Un ruleSet n'est jamais modifié après sa compilation: un rechargement en
+// crée un nouveau, et chaque flux conserve celui en vigueur à son début.
+type ruleSet struct {
+	source       ruleConfig
+	capture      []compiledCaptureRule
+	defaultMode  string // action si aucune règle de capture ne correspond
+	masker       *headerMasker
+	queryMasker  *headerMasker
+	cookieMasker *headerMasker
+	redactor     *bodyRedactor
+	pii          *piiScanner
+}
+
+// compileRules - Compiler les règles
+//
+// Les règles invalides sont ignorées et signalées dans l'erreur retournée,
+// le ruleSet restant utilisable avec les règles valides.
+func compileRules(rc ruleConfig) (*ruleSet, error) {
+	r := &ruleSet{source: rc, defaultMode: CaptureFull}
+	var errs []error
+
+	// Règles de capture, suivies des routes exclues historiques
+	if rc.CaptureDefault != "" {
+		if oneOf(rc.CaptureDefault, CaptureSkip, CaptureMetadata, CaptureFull) {
+			r.defaultMode = rc.CaptureDefault
+		} else {
+			errs = append(errs, fmt.Errorf("action de capture par défaut inconnue %q", rc.CaptureDefault))
+		}
+	}
+	captureRules := append([]CaptureRule{}, rc.CaptureRules...)
+	for _, route := range rc.ExcludedRoutes {
+		captureRules = append(captureRules, excludedRouteRules(route)...)
+	}
+	for i, rule := range captureRules {
+		compiled, err := compileCaptureRule(rule)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("règle de capture %d: %w", i, err))
+			continue
+		}
+		r.capture = append(r.capture, compiled)
+	}
+
+	// Règles de masquage des en-têtes
+	maskRules := make([]MaskRule, 0, len(rc.MaskHeaders)+len(rc.MaskRules))
+	for _, spec := range rc.MaskHeaders {
+		maskRules = append(maskRules, parseMaskRule(spec))
+	}
+	maskRules = append(maskRules, rc.MaskRules...)
+	masker, err := newHeaderMasker(maskRules, rc.MaskMode, rc.MaskHashKey)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("règles de masquage des en-têtes: %w", err))
+	}
+	r.masker = masker
+
+	r.queryMasker, err = newNameMasker(rc.MaskQueryParams, rc)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("règles de masquage des paramètres d'URL: %w", err))
+	}
+	r.cookieMasker, err = newNameMasker(rc.MaskCookies, rc)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("règles de masquage des cookies: %w", err))
+	}
+
+	r.redactor = newBodyRedactor(rc.BodyRedactRules)
+	r.pii, err = newPIIScanner(rc.PIIDetectors)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("détection des données personnelles désactivée: %w", err))
+	}
+
+	return r, errors.Join(errs...)
+}
+
+// newNameMasker - Compiler des règles de masquage par nom (paramètres, cookies)
+func newNameMasker(specs []string, rc ruleConfig) (*headerMasker, error) {
+	rules := make([]MaskRule, 0, len(specs))
+	for _, spec := range specs {
+		rules = append(rules, parseMaskRule(spec))
+	}
+	return newHeaderMasker(rules, rc.MaskMode, rc.MaskHashKey)
+}
+
+// captureAction - Action de la première règle de capture correspondant à la requête
+func (r *ruleSet) captureAction(req *proxy.Request, clientName string) string {
+	for i := range r.capture {
+		if r.capture[i].matches(req, clientName) {
+			return r.capture[i].action
+		}
+	}
+	return r.defaultMode
+}
+
+// logURL - Construire l'URL journalisée en masquant les paramètres sensibles
+func (r *ruleSet) logURL(u *url.URL, piiCounts map[string]int) string {
+	masked := *u
+	masked.RawQuery = r.queryMasker.MaskQuery(u.RawQuery)
+	if r.pii != nil {
+		return r.pii.ScanURL(&masked, piiCounts)
+	}
+	return masked.String()
+}
+
+// sanitizeBody - Masquer les champs JSON configurés puis les données personnelles d'un corps
+func (r *ruleSet) sanitizeBody(u *url.URL, contentType string, body []byte, piiCounts map[string]int) []byte {
+	body = r.redactor.Redact(u.Hostname(), u.Path, body)
+	if r.pii != nil {
+		body = r.pii.ScanBody(contentType, body, piiCounts)
+	}
+	return body
+}
+
+// maskHeaders - Convertir des en-têtes HTTP en map en masquant les en-têtes sensibles
+func (r *ruleSet) maskHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string)
+	for name, values := range header {
+		// Masquer les en-têtes sensibles, valeur par valeur
+		if mode, ok := r.masker.Match(name); ok {
+			masked := make([]string, len(values))
+			for i, value := range values {
+				masked[i] = r.masker.Mask(mode, value)
+			}
+			headers[name] = strings.Join(masked, ", ")
+			continue
+		}
+
+		// Masquer individuellement les cookies sensibles
+		if setCookie := strings.EqualFold(name, "Set-Cookie"); setCookie || strings.EqualFold(name, "Cookie") {
+			masked := make([]string, len(values))
+			for i, value := range values {
+				masked[i] = r.cookieMasker.MaskCookies(value, setCookie)
+			}
+			headers[name] = strings.Join(masked, ", ")
+			continue
+		}
+
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
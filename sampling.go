@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// Raisons d'une décision d'échantillonnage
+const (
+	SampleReasonRate       = "rate"        // retenu par le tirage
+	SampleReasonError      = "error"       // écarté par le tirage mais conservé: code >= 400
+	SampleReasonSlow       = "slow"        // écarté par le tirage mais conservé: requête lente
+	SampleReasonSampledOut = "sampled_out" // écarté
+)
+
+// SampleRule - Taux d'échantillonnage d'une route
+//
+// Host, Path et Methods suivent la syntaxe de CaptureRule; la première règle
+// correspondante fixe le taux (0 à 1) du flux.
+type SampleRule struct {
+	Host    string   `yaml:"host"`
+	Path    string   `yaml:"path"`
+	Methods []string `yaml:"methods"`
+	Rate    float64  `yaml:"rate"`
+}
+
+// SamplingDecision - Décision d'échantillonnage enregistrée sur l'entrée
+type SamplingDecision struct {
+	Rate   float64 `json:"rate"`
+	Kept   bool    `json:"kept"`
+	Reason string  `json:"reason"`
+}
+
+// sampler - Politique d'échantillonnage des flux
+//
+// Le tirage est fait à la requête. Un flux écarté n'est envoyé qu'à sa fin:
+// il est alors conservé s'il a échoué ou s'il a été lent, sinon il est
+// ignoré ou journalisé sans ses corps selon outAction.
+type sampler struct {
+	rate          float64
+	rules         []compiledSampleRule
+	keepErrors    bool
+	keepSlow      time.Duration
+	byCorrelation bool
+	outAction     string
+	sampledOut    atomic.Int64 // flux écartés, ignorés ou réduits
+}
+
+type compiledSampleRule struct {
+	match compiledCaptureRule
+	rate  float64
+}
+
+// newSampler - Compiler la politique d'échantillonnage de la configuration
+func newSampler(c Config) (*sampler, error) {
+	s := &sampler{
+		rate:          c.SampleRate,
+		keepErrors:    c.SampleKeepErrors,
+		keepSlow:      c.SampleKeepSlow,
+		byCorrelation: c.SampleByCorrelation,
+		outAction:     c.SampleOutAction,
+	}
+	if s.outAction == "" {
+		s.outAction = CaptureMetadata
+	}
+
+	var errs []error
+	if s.rate <= 0 || s.rate > 1 {
+		errs = append(errs, fmt.Errorf("taux global hors de ]0, 1]: %v", s.rate))
+	}
+	if !oneOf(s.outAction, CaptureSkip, CaptureMetadata) {
+		errs = append(errs, fmt.Errorf("sort des flux écartés inconnu %q", s.outAction))
+	}
+	for i, rule := range c.SampleRules {
+		match, err := compileCaptureRule(CaptureRule{Host: rule.Host, Path: rule.Path, Methods: rule.Methods, Action: CaptureFull})
+		if err == nil && (rule.Rate < 0 || rule.Rate > 1) {
+			err = fmt.Errorf("taux hors de [0, 1]: %v", rule.Rate)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("règle d'échantillonnage %d: %w", i, err))
+			continue
+		}
+		s.rules = append(s.rules, compiledSampleRule{match: match, rate: rule.Rate})
+	}
+	return s, errors.Join(errs...)
+}
+
+// parseSampleRules - Lire des règles au format "hôte/chemin=taux;..."
+func parseSampleRules(spec string) ([]SampleRule, error) {
+	var rules []SampleRule
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		target, rawRate, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("règle d'échantillonnage invalide %q: \"=\" attendu", part)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rawRate), 64)
+		if err != nil {
+			return nil, fmt.Errorf("règle d'échantillonnage invalide %q: taux attendu", part)
+		}
+
+		rule := SampleRule{Rate: rate}
+		if i := strings.Index(target, "/"); i >= 0 {
+			rule.Host, rule.Path = target[:i], target[i:]
+		} else {
+			rule.Host = target
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// enabled - Indiquer si des flux peuvent être écartés
+func (s *sampler) enabled() bool {
+	return s.rate < 1 || len(s.rules) > 0
+}
+
+// decide - Tirer la décision d'échantillonnage d'une requête (nil si désactivé)
+//
+// Avec byCorrelation, le tirage dépend uniquement de l'identifiant de
+// corrélation: les flux qui le partagent sont conservés ou écartés ensemble
+// (à taux égal; à taux différents, un flux conservé au taux le plus faible
+// l'est aussi au plus élevé).
+func (s *sampler) decide(req *proxy.Request, clientName, correlationID string) *SamplingDecision {
+	if !s.enabled() {
+		return nil
+	}
+
+	rate := s.rate
+	for i := range s.rules {
+		if s.rules[i].match.matches(req, clientName) {
+			rate = s.rules[i].rate
+			break
+		}
+	}
+
+	draw := rand.Float64()
+	if s.byCorrelation {
+		draw = hashDraw(correlationID)
+	}
+	if draw < rate {
+		return &SamplingDecision{Rate: rate, Kept: true, Reason: SampleReasonRate}
+	}
+	return &SamplingDecision{Rate: rate, Reason: SampleReasonSampledOut}
+}
+
+// keepFinished - Réexaminer un flux écarté une fois terminé
+//
+// Retourne false si le flux doit être ignoré; sinon l'entrée est prête à
+// être envoyée, conservée entière ou réduite à ses métadonnées.
+func (s *sampler) keepFinished(entry *LogModel) bool {
+	decision := entry.Sampling
+	switch {
+	case s.keepErrors && entry.HTTPReturnCode >= 400:
+		decision.Kept, decision.Reason = true, SampleReasonError
+		return true
+	case s.keepSlow > 0 && time.Duration(entry.ExecutionTime)*time.Millisecond >= s.keepSlow:
+		decision.Kept, decision.Reason = true, SampleReasonSlow
+		return true
+	}
+
+	s.sampledOut.Add(1)
+	if s.outAction == CaptureSkip {
+		return false
+	}
+	omitEntryBodies(entry)
+	return true
+}
+
+// omitEntryBodies - Retirer les corps d'une entrée en gardant leurs tailles
+func omitEntryBodies(entry *LogModel) {
+	entry.CaptureMode = CaptureMetadata
+	entry.HTTPBody, entry.HTTPBodyTruncated = "", false
+	if entry.HTTPBodySize > 0 {
+		entry.HTTPBodyEncoding = BodyEncodingOmitted
+	}
+	entry.HTTPReturnBody, entry.HTTPReturnBodyTruncated = "", false
+	if entry.HTTPReturnBodySize > 0 {
+		entry.HTTPReturnBodyEncoding = BodyEncodingOmitted
+	}
+}
+
+// hashDraw - Tirage déterministe dans [0, 1) à partir d'une clé
+func hashDraw(key string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// queueFinal - Envoyer l'entrée d'un flux terminé
+//
+// Un flux conservé dès la requête est mis à jour; un flux écarté n'a pas
+// encore été créé et est envoyé d'un bloc s'il est finalement conservé.
+func (h *MITMHandler) queueFinal(logEntry *LogModel) {
+	if decision := logEntry.Sampling; decision != nil && !decision.Kept {
+		if h.sampler.keepFinished(logEntry) {
+			h.queueLog(logEntry, "create")
+		}
+		return
+	}
+	h.queueLog(logEntry, "update")
+}
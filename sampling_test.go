@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// runSampledFlow - Faire passer un flux complet par le gestionnaire
+func runSampledFlow(handler *MITMHandler, method, rawURL string, status int, delay time.Duration) {
+	f := newTestFlow(method, rawURL, []byte("requête"))
+	handler.Request(f)
+	time.Sleep(delay)
+	f.Response = &proxy.Response{StatusCode: status, Header: make(http.Header), Body: []byte("réponse")}
+	handler.Response(f)
+}
+
+// TestSamplingKeepsErrors vérifie qu'un flux écarté est conservé en entier s'il échoue
+func TestSamplingKeepsErrors(t *testing.T) {
+	handler, sink := newTestHandler(Config{
+		SampleRules:      []SampleRule{{Methods: []string{"GET"}, Rate: 0}},
+		SampleKeepErrors: true,
+		SampleOutAction:  CaptureSkip,
+	})
+
+	runSampledFlow(handler, "GET", "http://example.com/ok", 200, 0)
+	runSampledFlow(handler, "GET", "http://example.com/ko", 502, 0)
+	runSampledFlow(handler, "POST", "http://example.com/ok", 200, 0)
+	handler.Close()
+
+	creates := sink.entries(t, "create")
+	assert.Len(t, creates, 2, "Le GET réussi doit être écarté")
+	for _, entry := range creates {
+		switch entry.HTTPMethod {
+		case "GET":
+			assert.Equal(t, &SamplingDecision{Rate: 0, Kept: true, Reason: SampleReasonError}, entry.Sampling)
+			assert.Equal(t, 502, entry.HTTPReturnCode, "Le flux conservé à sa fin doit être envoyé complet")
+			assert.Equal(t, "réponse", entry.HTTPReturnBody)
+		case "POST":
+			assert.Equal(t, &SamplingDecision{Rate: 1, Kept: true, Reason: SampleReasonRate}, entry.Sampling)
+		}
+	}
+
+	updates := sink.entries(t, "update")
+	assert.Len(t, updates, 1, "Seul le flux conservé dès la requête doit être mis à jour")
+	assert.Equal(t, "POST", updates[0].HTTPMethod)
+	assert.Equal(t, int64(1), handler.sampler.sampledOut.Load())
+}
+
+// TestSamplingMetadataAndSlowRequests vérifie la réduction des flux écartés et la conservation des flux lents
+func TestSamplingMetadataAndSlowRequests(t *testing.T) {
+	handler, sink := newTestHandler(Config{
+		SampleRules:     []SampleRule{{Path: "/api/**", Rate: 0}},
+		SampleKeepSlow:  20 * time.Millisecond,
+		SampleOutAction: CaptureMetadata,
+	})
+
+	runSampledFlow(handler, "GET", "http://example.com/api/fast", 200, 0)
+	runSampledFlow(handler, "GET", "http://example.com/api/slow", 200, 30*time.Millisecond)
+	handler.Close()
+
+	creates := sink.entries(t, "create")
+	assert.Len(t, creates, 2)
+	for _, entry := range creates {
+		switch entry.HTTPUrl {
+		case "http://example.com/api/fast":
+			assert.Equal(t, SampleReasonSampledOut, entry.Sampling.Reason)
+			assert.False(t, entry.Sampling.Kept)
+			assert.Equal(t, CaptureMetadata, entry.CaptureMode)
+			assert.Empty(t, entry.HTTPBody, "Les corps d'un flux écarté ne doivent pas être journalisés")
+			assert.Empty(t, entry.HTTPReturnBody)
+			assert.Equal(t, BodyEncodingOmitted, entry.HTTPReturnBodyEncoding)
+			assert.Equal(t, len("réponse"), entry.HTTPReturnBodySize)
+		case "http://example.com/api/slow":
+			assert.Equal(t, SampleReasonSlow, entry.Sampling.Reason, "Un flux lent doit être conservé")
+			assert.Equal(t, "requête", entry.HTTPBody)
+		}
+	}
+	assert.Empty(t, sink.entries(t, "update"))
+}
+
+// TestSamplingByCorrelation vérifie que les flux d'une même corrélation sont conservés ou écartés ensemble
+func TestSamplingByCorrelation(t *testing.T) {
+	s, err := newSampler(Config{SampleRate: 0.5, SampleByCorrelation: true})
+	assert.NoError(t, err)
+
+	kept := 0
+	for i := 0; i < 200; i++ {
+		correlationID := fmt.Sprintf("corr-%d", i)
+		first := s.decide(newTestFlow("GET", "http://example.com/a", nil).Request, "Anonyme", correlationID)
+		second := s.decide(newTestFlow("POST", "http://other.example.com/b", nil).Request, "Anonyme", correlationID)
+		assert.Equal(t, first.Kept, second.Kept, "La décision doit être commune à la corrélation %s", correlationID)
+		if first.Kept {
+			kept++
+		}
+	}
+	assert.InDelta(t, 100, kept, 30, "Environ la moitié des corrélations doit être conservée")
+}
+
+// TestSamplingDisabled vérifie qu'aucune décision n'est enregistrée sans échantillonnage
+func TestSamplingDisabled(t *testing.T) {
+	handler, sink := newTestHandler(Config{})
+
+	runSampledFlow(handler, "GET", "http://example.com/", 200, 0)
+	handler.Close()
+
+	creates := sink.entries(t, "create")
+	assert.Len(t, creates, 1)
+	assert.Nil(t, creates[0].Sampling)
+}
+
+// TestSampleRulesParsingAndValidation vérifie le format compact et le rejet des taux invalides
+func TestSampleRulesParsingAndValidation(t *testing.T) {
+	rules, err := parseSampleRules("*/api/**=0.1; static.example.com=0")
+	assert.NoError(t, err)
+	assert.Equal(t, []SampleRule{
+		{Host: "*", Path: "/api/**", Rate: 0.1},
+		{Host: "static.example.com", Rate: 0},
+	}, rules)
+
+	_, err = parseSampleRules("*/api=beaucoup")
+	assert.Error(t, err)
+
+	for _, config := range []Config{
+		{SampleRate: 1.5},
+		{SampleRate: 0},
+		{SampleRate: 1, SampleRules: []SampleRule{{Path: "/api", Rate: -1}}},
+		{SampleRate: 1, SampleOutAction: CaptureFull},
+	} {
+		_, err := newSampler(config)
+		assert.Error(t, err, "La configuration %+v doit être refusée", config)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Noms des sorties de journalisation disponibles
+const (
+	SinkLogger = "logger" // API REST du microservice Logger
+	SinkFile   = "file"   // fichier JSONL local avec rotation
+	SinkStdout = "stdout" // sortie standard
+)
+
+// Sink - Destination des entrées de journal produites par MITMHandler
+//
+// Write reçoit l'action ("create", "update" ou "connection") et l'entrée déjà
+// sérialisée en JSON. Les implémentations doivent être sûres pour un usage
+// concurrent, les workers d'envoi appelant Write en parallèle.
+type Sink interface {
+	Name() string
+	Write(action string, entry []byte) error
+	Close() error
+}
+
+// encodeSinkLine - Encoder une entrée sous forme de ligne JSONL
+func encodeSinkLine(action string, entry []byte) ([]byte, error) {
+	line, err := json.Marshal(SpoolRecord{Action: action, Entry: entry})
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// stdoutSink - Écriture des journaux en JSONL sur la sortie standard
+type stdoutSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// newStdoutSink - Créer une sortie écrivant sur le flux donné
+func newStdoutSink(out io.Writer) *stdoutSink {
+	return &stdoutSink{out: out}
+}
+
+// Name - Nom de la sortie
+func (s *stdoutSink) Name() string {
+	return SinkStdout
+}
+
+// Write - Écrire une ligne JSONL
+func (s *stdoutSink) Write(action string, entry []byte) error {
+	line, err := encodeSinkLine(action, entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.out.Write(line)
+	return err
+}
+
+// Close - Rien à fermer pour la sortie standard
+func (s *stdoutSink) Close() error {
+	return nil
+}
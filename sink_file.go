@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fileSink - Écriture des journaux dans un fichier JSONL local avec rotation
+//
+// Lorsque le fichier atteint maxBytes, il est renommé en <fichier>.1, les
+// archives existantes sont décalées et la plus ancienne au-delà de
+// maxBackups est supprimée.
+type fileSink struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// newFileSink - Ouvrir (ou créer) le fichier de journalisation
+func newFileSink(path string, maxBytes int64, maxBackups int) (*fileSink, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("création du répertoire de journalisation: %w", err)
+		}
+	}
+
+	s := &fileSink{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Name - Nom de la sortie
+func (s *fileSink) Name() string {
+	return SinkFile
+}
+
+// Write - Ajouter une ligne JSONL en effectuant la rotation si nécessaire
+func (s *fileSink) Write(action string, entry []byte) error {
+	line, err := encodeSinkLine(action, entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return errors.New("fichier de journalisation fermé")
+	}
+
+	if s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// Close - Fermer le fichier de journalisation
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// open - Ouvrir le fichier courant en ajout
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("ouverture du fichier de journalisation: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// rotate - Archiver le fichier courant et en ouvrir un nouveau
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	// Décaler les archives existantes: .N-1 -> .N, ..., courant -> .1
+	os.Remove(s.backupPath(s.maxBackups))
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		os.Rename(s.backupPath(i), s.backupPath(i+1))
+	}
+	if s.maxBackups > 0 {
+		if err := os.Rename(s.path, s.backupPath(1)); err != nil {
+			return fmt.Errorf("rotation du fichier de journalisation: %w", err)
+		}
+	} else {
+		os.Remove(s.path)
+	}
+
+	return s.open()
+}
+
+func (s *fileSink) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", s.path, n)
+}
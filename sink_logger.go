@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// loggerSink - Envoi des journaux à l'API REST du microservice Logger
+//
+// Les échecs sont réessayés avec backoff puis écrits dans le spool disque
+// (si configuré), rejoué dans l'ordre par un drainer dès que le logger répond.
+type loggerSink struct {
+	config     Config
+	httpClient *http.Client
+	spool      *Spool
+	batcher    *batchDispatcher
+	stop       chan struct{}
+	wg         sync.WaitGroup
+
+	// drainCtx interrompt l'envoi en cours du drainer à la fermeture
+	drainCtx    context.Context
+	cancelDrain context.CancelFunc
+}
+
+// newLoggerSink - Créer la sortie Logger, son spool et son dispatcher de lots
+func newLoggerSink(config Config) *loggerSink {
+	s := &loggerSink{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		stop: make(chan struct{}),
+	}
+	s.drainCtx, s.cancelDrain = context.WithCancel(context.Background())
+
+	// Ouvrir le spool et démarrer le drainer si configuré
+	if config.SpoolDir != "" {
+		spool, err := OpenSpool(config.SpoolDir, config.SpoolSegmentBytes, config.SpoolMaxBytes, config.SpoolFsync)
+		if err != nil {
+			log.Printf("Spool désactivé: %v", err)
+		} else {
+			s.spool = spool
+			s.wg.Add(1)
+			go s.drainSpool()
+			if config.SpoolFsync == SpoolFsyncInterval {
+				s.wg.Add(1)
+				go s.syncSpool()
+			}
+		}
+	}
+
+	// Démarrer le dispatcher de lots si activé
+	if config.BatchEnabled {
+		s.batcher = newBatchDispatcher(config, s.httpClient, s.spoolBatch)
+	}
+
+	return s
+}
+
+// Name - Nom de la sortie
+func (s *loggerSink) Name() string {
+	return SinkLogger
+}
+
+// Close - Envoyer le lot en cours, arrêter le drainer et fermer le spool
+func (s *loggerSink) Close() error {
+	if s.batcher != nil {
+		s.batcher.Close()
+	}
+	close(s.stop)
+	s.cancelDrain()
+	s.wg.Wait()
+	if s.spool != nil {
+		return s.spool.Close()
+	}
+	return nil
+}
+
+// Write - Livrer une entrée au logger (spool, lot ou envoi direct)
+func (s *loggerSink) Write(action string, jsonData []byte) error {
+	var err error
+
+	// Préserver l'ordre: tant que le spool n'est pas vidé, les nouvelles entrées passent derrière
+	if s.spool != nil && s.spool.Pending() {
+		return s.spoolLog(action, jsonData)
+	}
+
+	// En mode lot, le dispatcher se charge de l'envoi et des tentatives. Les
+	// événements de connexion gardent leur point de terminaison dédié: le
+	// point de terminaison bulk ne reçoit que des flux
+	if s.batcher != nil && action != "connection" {
+		s.batcher.Add(SpoolRecord{Action: action, Entry: jsonData})
+		return nil
+	}
+
+	// Envoyer le journal au logger avec des tentatives
+	statusCode := 0
+	for i := 0; i <= s.config.MaxRetries; i++ {
+		statusCode, err = s.postLog(context.Background(), s.loggerEndpoint(action), jsonData)
+		if err == nil && statusCode < 500 {
+			break
+		}
+
+		if i < s.config.MaxRetries {
+			// Backoff exponentiel
+			backoff := s.config.RetryDelay * time.Duration(1<<uint(i))
+			time.Sleep(backoff)
+		}
+	}
+
+	if statusCode >= 400 && statusCode < 500 {
+		return fmt.Errorf("journal rejeté par le logger avec le code d'état %d", statusCode)
+	}
+	if err != nil || statusCode >= 500 {
+		log.Printf("Échec de l'envoi du journal au logger après %d tentatives", s.config.MaxRetries)
+		if s.spool != nil {
+			return s.spoolLog(action, jsonData)
+		}
+		if err == nil {
+			err = fmt.Errorf("code d'état %d", statusCode)
+		}
+		return err
+	}
+	return nil
+}
+
+// loggerEndpoint - Déterminer le point de terminaison en fonction de l'action
+func (s *loggerSink) loggerEndpoint(action string) string {
+	switch action {
+	case "update":
+		return fmt.Sprintf("%s/update", s.config.LoggerEndpoint)
+	case "connection":
+		return fmt.Sprintf("%s/connection", s.config.LoggerEndpoint)
+	}
+	return s.config.LoggerEndpoint
+}
+
+// postLog - Effectuer une seule tentative d'envoi vers le logger
+func (s *loggerSink) postLog(ctx context.Context, endpoint string, jsonData []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Spill - Écrire directement une entrée dans le spool (débordement de la file d'envoi)
+func (s *loggerSink) Spill(action string, jsonData []byte) bool {
+	if s.spool == nil {
+		return false
+	}
+	return s.spool.Append(SpoolRecord{Action: action, Entry: jsonData}) == nil
+}
+
+// spoolLog - Écrire une entrée non livrée dans le spool
+func (s *loggerSink) spoolLog(action string, jsonData []byte) error {
+	if err := s.spool.Append(SpoolRecord{Action: action, Entry: jsonData}); err != nil {
+		return fmt.Errorf("écriture dans le spool impossible, journal perdu: %w", err)
+	}
+	return nil
+}
+
+// spoolBatch - Écrire dans le spool les éléments d'un lot non livré
+func (s *loggerSink) spoolBatch(records []SpoolRecord) {
+	if s.spool == nil {
+		return
+	}
+	for _, rec := range records {
+		if err := s.spoolLog(rec.Action, rec.Entry); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// drainSpool - Rejouer dans l'ordre les entrées du spool dès que le logger répond
+func (s *loggerSink) drainSpool() {
+	defer s.wg.Done()
+
+	const maxBackoff = 30 * time.Second
+	backoff := s.config.RetryDelay
+
+	for {
+		wait := time.Duration(0)
+
+		rec, pos, ok, err := s.spool.Next()
+		switch {
+		case err != nil:
+			log.Printf("Erreur lors de la lecture du spool: %v", err)
+			wait = s.config.SpoolDrainInterval
+		case !ok:
+			wait = s.config.SpoolDrainInterval
+		default:
+			statusCode, err := s.postLog(s.drainCtx, s.loggerEndpoint(rec.Action), rec.Entry)
+			if err != nil || statusCode >= 500 {
+				// Le logger est toujours indisponible: réessayer plus tard
+				wait = backoff
+				backoff *= 2
+				if backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+				break
+			}
+			if statusCode >= 400 {
+				log.Printf("Journal du spool rejeté par le logger avec le code d'état %d", statusCode)
+			}
+			backoff = s.config.RetryDelay
+			s.spool.Ack(pos)
+		}
+
+		if wait == 0 {
+			select {
+			case <-s.stop:
+				return
+			default:
+				continue
+			}
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// syncSpool - Synchroniser périodiquement le spool sur disque (politique "interval")
+//
+// Indépendant du drainer: pendant une panne du logger le spool n'est jamais vide.
+func (s *loggerSink) syncSpool() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.SpoolDrainInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.spool.Sync(); err != nil {
+				log.Printf("Erreur lors de la synchronisation du spool: %v", err)
+			}
+		}
+	}
+}
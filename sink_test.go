@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFileSinkRotation vérifie la rotation du fichier JSONL et la limite d'archives
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "proxy.jsonl")
+	sink, err := newFileSink(path, 60, 2)
+	assert.NoError(t, err, "La sortie fichier doit s'ouvrir sans erreur")
+
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, sink.Write("create", []byte(`{"id":"abc"}`)))
+	}
+	assert.NoError(t, sink.Close())
+
+	assert.FileExists(t, path)
+	assert.FileExists(t, path+".1")
+	assert.FileExists(t, path+".2")
+	assert.NoFileExists(t, path+".3", "Les archives au-delà de la limite doivent être supprimées")
+
+	data, _ := os.ReadFile(path)
+	var rec SpoolRecord
+	assert.NoError(t, json.Unmarshal(bytes.SplitN(data, []byte("\n"), 2)[0], &rec), "Chaque ligne doit être un objet JSON")
+	assert.Equal(t, "create", rec.Action)
+}
+
+// TestHandlerFansOutToAllSinks vérifie que chaque entrée est distribuée à toutes les sorties configurées
+func TestHandlerFansOutToAllSinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.jsonl")
+	handler := NewMITMHandler(withDefaults(Config{
+		Sinks:       []string{SinkFile, SinkStdout},
+		LogFilePath: path,
+	}))
+	var out bytes.Buffer
+	for i, sink := range handler.sinks {
+		if sink.Name() == SinkStdout {
+			handler.sinks[i] = newStdoutSink(&out)
+		}
+	}
+
+	handler.queueLog(&LogModel{ID: "1", OccuredTime: time.Now()}, "create")
+	assert.NoError(t, handler.Close())
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"), "La sortie fichier doit recevoir l'entrée")
+	assert.Equal(t, 1, strings.Count(out.String(), "\n"), "La sortie standard doit recevoir l'entrée")
+	assert.Nil(t, handler.logger, "La sortie Logger ne doit pas être créée si elle n'est pas configurée")
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Politiques de synchronisation disque du spool
+const (
+	SpoolFsyncAlways   = "always"   // fsync après chaque écriture
+	SpoolFsyncInterval = "interval" // fsync périodique (SpoolDrainInterval)
+	SpoolFsyncNever    = "never"    // laisser le système gérer le cache
+)
+
+const (
+	spoolSegmentExt  = ".seg"
+	spoolCursorFile  = "cursor"
+	spoolSegmentName = "%016d" + spoolSegmentExt
+)
+
+// SpoolRecord - Enregistrement persisté dans le spool en attente de livraison
+type SpoolRecord struct {
+	Action string          `json:"action"`
+	Entry  json.RawMessage `json:"entry"`
+}
+
+// SpoolPosition - Position de lecture retournée par Next et passée à Ack
+type SpoolPosition struct {
+	Seg uint64
+	Off int64
+}
+
+// Spool - Journal d'écriture anticipée sur disque, découpé en segments
+//
+// Les enregistrements sont ajoutés en fin du segment courant (une ligne JSON
+// par enregistrement) et relus dans l'ordre d'écriture. La position de lecture
+// est persistée dans un fichier curseur pour survivre aux redémarrages.
+type Spool struct {
+	dir          string
+	segmentBytes int64
+	maxBytes     int64
+	fsync        string
+
+	mu         sync.Mutex
+	writeSeg   uint64
+	writeFile  *os.File
+	writeSize  int64
+	readSeg    uint64
+	readOff    int64
+	totalBytes int64
+	dirty      bool
+}
+
+// OpenSpool - Ouvrir (ou créer) un spool dans le répertoire donné
+func OpenSpool(dir string, segmentBytes, maxBytes int64, fsync string) (*Spool, error) {
+	switch fsync {
+	case SpoolFsyncAlways, SpoolFsyncInterval, SpoolFsyncNever:
+	default:
+		return nil, fmt.Errorf("politique fsync inconnue: %q", fsync)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("création du répertoire de spool: %w", err)
+	}
+
+	s := &Spool{
+		dir:          dir,
+		segmentBytes: segmentBytes,
+		maxBytes:     maxBytes,
+		fsync:        fsync,
+	}
+
+	segments, err := s.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range segments {
+		info, err := os.Stat(s.segmentPath(seg))
+		if err != nil {
+			return nil, err
+		}
+		s.totalBytes += info.Size()
+	}
+
+	if len(segments) == 0 {
+		s.writeSeg = 1
+		s.readSeg = 1
+	} else {
+		s.writeSeg = segments[len(segments)-1]
+		s.readSeg = segments[0]
+	}
+
+	// Reprendre la position de lecture si elle est encore valide
+	if seg, off, err := s.readCursor(); err == nil && seg >= s.readSeg && seg <= s.writeSeg {
+		s.readSeg = seg
+		s.readOff = off
+	}
+
+	file, err := os.OpenFile(s.segmentPath(s.writeSeg), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("ouverture du segment de spool: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.writeFile = file
+	s.writeSize = info.Size()
+
+	return s, nil
+}
+
+// Append - Ajouter un enregistrement en fin de spool
+func (s *Spool) Append(rec SpoolRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writeFile == nil {
+		return errors.New("spool fermé")
+	}
+
+	// Passer au segment suivant si le segment courant est plein
+	if s.writeSize > 0 && s.writeSize+int64(len(line)) > s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.writeFile.Write(line)
+	s.writeSize += int64(n)
+	s.totalBytes += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if s.fsync == SpoolFsyncAlways {
+		if err := s.writeFile.Sync(); err != nil {
+			return err
+		}
+	} else {
+		s.dirty = true
+	}
+
+	s.enforceMaxBytes()
+	return nil
+}
+
+// Next - Lire le prochain enregistrement non acquitté sans avancer le curseur
+//
+// Retourne la position à passer à Ack une fois l'enregistrement livré.
+func (s *Spool) Next() (SpoolRecord, SpoolPosition, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		line, next, err := s.readLine(s.readSeg, s.readOff)
+		if err != nil {
+			return SpoolRecord{}, SpoolPosition{}, false, err
+		}
+
+		if line == nil {
+			// Fin du segment: passer au suivant s'il existe
+			if s.readSeg < s.writeSeg {
+				s.dropSegment(s.readSeg)
+				s.readSeg++
+				s.readOff = 0
+				s.writeCursor()
+				continue
+			}
+			return SpoolRecord{}, SpoolPosition{}, false, nil
+		}
+
+		var rec SpoolRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			log.Printf("Enregistrement de spool illisible ignoré (segment %d, position %d): %v", s.readSeg, s.readOff, err)
+			s.readOff = next
+			s.writeCursor()
+			continue
+		}
+		return rec, SpoolPosition{Seg: s.readSeg, Off: next}, true, nil
+	}
+}
+
+// Ack - Acquitter les enregistrements lus jusqu'à la position donnée
+//
+// La position est ignorée si le segment lu a été supprimé entre-temps
+// (plafond de taille atteint): la lecture reprend au début du segment suivant.
+func (s *Spool) Ack(pos SpoolPosition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pos.Seg != s.readSeg || pos.Off < s.readOff {
+		return
+	}
+	s.readOff = pos.Off
+	s.writeCursor()
+}
+
+// Pending - Indiquer si des enregistrements sont en attente de livraison
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.readSeg < s.writeSeg || s.readOff < s.writeSize
+}
+
+// Size - Taille totale des segments présents sur disque, en octets
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.totalBytes
+}
+
+// Sync - Forcer l'écriture sur disque des données en attente
+func (s *Spool) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty || s.writeFile == nil {
+		return nil
+	}
+	s.dirty = false
+	return s.writeFile.Sync()
+}
+
+// Close - Fermer le spool en synchronisant les données en attente
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writeFile == nil {
+		return nil
+	}
+	if s.fsync != SpoolFsyncNever {
+		s.writeFile.Sync()
+	}
+	err := s.writeFile.Close()
+	s.writeFile = nil
+	return err
+}
+
+// rotate - Fermer le segment courant et en ouvrir un nouveau
+func (s *Spool) rotate() error {
+	if s.fsync != SpoolFsyncNever {
+		s.writeFile.Sync()
+	}
+	s.writeFile.Close()
+
+	s.writeSeg++
+	file, err := os.OpenFile(s.segmentPath(s.writeSeg), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		s.writeFile = nil
+		return fmt.Errorf("ouverture du segment de spool: %w", err)
+	}
+	s.writeFile = file
+	s.writeSize = 0
+	s.dirty = false
+	return nil
+}
+
+// enforceMaxBytes - Supprimer les segments les plus anciens si le plafond est dépassé
+func (s *Spool) enforceMaxBytes() {
+	if s.maxBytes <= 0 {
+		return
+	}
+	for s.totalBytes > s.maxBytes && s.readSeg < s.writeSeg {
+		log.Printf("Spool plein (%d octets > %d): suppression du segment %d non livré", s.totalBytes, s.maxBytes, s.readSeg)
+		s.dropSegment(s.readSeg)
+		s.readSeg++
+		s.readOff = 0
+		s.writeCursor()
+	}
+}
+
+// dropSegment - Supprimer un segment du disque
+func (s *Spool) dropSegment(seg uint64) {
+	path := s.segmentPath(seg)
+	if info, err := os.Stat(path); err == nil {
+		s.totalBytes -= info.Size()
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Erreur lors de la suppression du segment de spool %s: %v", path, err)
+	}
+}
+
+// readLine - Lire une ligne complète à la position donnée (nil si aucune)
+func (s *Spool) readLine(seg uint64, off int64) ([]byte, int64, error) {
+	file, err := os.Open(s.segmentPath(seg))
+	if os.IsNotExist(err) {
+		return nil, off, nil
+	}
+	if err != nil {
+		return nil, off, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(off, io.SeekStart); err != nil {
+		return nil, off, err
+	}
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err == io.EOF {
+		// Ligne incomplète (écriture interrompue) ou fin du segment
+		return nil, off, nil
+	}
+	if err != nil {
+		return nil, off, err
+	}
+	return line[:len(line)-1], off + int64(len(line)), nil
+}
+
+// listSegments - Lister les numéros de segments présents, triés
+func (s *Spool) listSegments() ([]uint64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		seg, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seg)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// readCursor - Lire la position de lecture persistée
+func (s *Spool) readCursor() (uint64, int64, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if err != nil {
+		return 0, 0, err
+	}
+	var seg uint64
+	var off int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seg, &off); err != nil {
+		return 0, 0, err
+	}
+	return seg, off, nil
+}
+
+// writeCursor - Persister la position de lecture de manière atomique
+func (s *Spool) writeCursor() {
+	path := filepath.Join(s.dir, spoolCursorFile)
+	tmp := path + ".tmp"
+	data := []byte(fmt.Sprintf("%d %d\n", s.readSeg, s.readOff))
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		log.Printf("Erreur lors de l'écriture du curseur de spool: %v", err)
+		return
+	}
+	_, err = file.Write(data)
+	if err == nil && s.fsync == SpoolFsyncAlways {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		log.Printf("Erreur lors de l'écriture du curseur de spool: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("Erreur lors de l'écriture du curseur de spool: %v", err)
+	}
+}
+
+func (s *Spool) segmentPath(seg uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf(spoolSegmentName, seg))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSpoolReplayOrderAcrossRestart vérifie que les enregistrements sont relus dans l'ordre après réouverture
+func TestSpoolReplayOrderAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := OpenSpool(dir, 64, 0, SpoolFsyncAlways)
+	assert.NoError(t, err, "Le spool doit s'ouvrir sans erreur")
+	for _, id := range []string{"a", "b", "c"} {
+		entry, _ := json.Marshal(LogModel{ID: id})
+		assert.NoError(t, spool.Append(SpoolRecord{Action: "create", Entry: entry}))
+	}
+
+	// Acquitter le premier enregistrement puis simuler un redémarrage
+	rec, next, ok, err := spool.Next()
+	assert.NoError(t, err)
+	assert.True(t, ok, "Un enregistrement doit être disponible")
+	assert.Contains(t, string(rec.Entry), `"id":"a"`)
+	spool.Ack(next)
+	assert.NoError(t, spool.Close())
+
+	spool, err = OpenSpool(dir, 64, 0, SpoolFsyncAlways)
+	assert.NoError(t, err, "Le spool doit se rouvrir sans erreur")
+	defer spool.Close()
+
+	var ids []string
+	for {
+		rec, next, ok, err := spool.Next()
+		assert.NoError(t, err)
+		if !ok {
+			break
+		}
+		var entry LogModel
+		assert.NoError(t, json.Unmarshal(rec.Entry, &entry))
+		ids = append(ids, entry.ID)
+		spool.Ack(next)
+	}
+	assert.Equal(t, []string{"b", "c"}, ids, "Les enregistrements restants doivent être relus dans l'ordre")
+	assert.False(t, spool.Pending(), "Le spool doit être vide après relecture")
+}
+
+// TestSpoolMaxBytesDropsOldestSegments vérifie que le plafond de taille supprime les segments les plus anciens
+func TestSpoolMaxBytesDropsOldestSegments(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 50, 120, SpoolFsyncNever)
+	assert.NoError(t, err)
+	defer spool.Close()
+
+	entry := json.RawMessage(`{"id":"x"}`)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, spool.Append(SpoolRecord{Action: "create", Entry: entry}))
+	}
+
+	assert.LessOrEqual(t, spool.Size(), int64(120), "La taille du spool ne doit pas dépasser le plafond")
+	assert.True(t, spool.Pending(), "Les enregistrements les plus récents doivent être conservés")
+}
+
+// TestLoggerSinkSpoolsAndDrains vérifie que les journaux non livrés sont rejoués quand le logger revient
+func TestLoggerSinkSpoolsAndDrains(t *testing.T) {
+	var healthy atomic.Bool
+	var received atomic.Int32
+	loggerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer loggerServer.Close()
+
+	handler := NewMITMHandler(withDefaults(Config{
+		LoggerEndpoint:     loggerServer.URL,
+		MaxRetries:         1,
+		RetryDelay:         time.Millisecond,
+		SpoolDir:           t.TempDir(),
+		SpoolDrainInterval: 10 * time.Millisecond,
+	}))
+	defer handler.Close()
+
+	assert.NoError(t, handler.logger.Write("create", []byte(`{"id":"1"}`)), "L'échec doit être absorbé par le spool")
+	assert.True(t, handler.logger.spool.Pending(), "Le journal doit être écrit dans le spool")
+
+	healthy.Store(true)
+	assert.Eventually(t, func() bool { return received.Load() == 1 }, 2*time.Second, 10*time.Millisecond,
+		"Le journal du spool doit être livré une fois le logger disponible")
+}
+
+// TestSpoolAckIgnoresDroppedSegment vérifie qu'un acquittement tardif ne s'applique pas au segment suivant
+func TestSpoolAckIgnoresDroppedSegment(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 40, 80, SpoolFsyncNever)
+	assert.NoError(t, err)
+	defer spool.Close()
+
+	assert.NoError(t, spool.Append(SpoolRecord{Action: "create", Entry: json.RawMessage(`{"id":"a"}`)}))
+	_, pos, ok, err := spool.Next()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Le plafond supprime le segment en cours de lecture avant l'acquittement
+	for _, id := range []string{"b", "c", "d"} {
+		assert.NoError(t, spool.Append(SpoolRecord{Action: "create", Entry: json.RawMessage(`{"id":"` + id + `"}`)}))
+	}
+	spool.Ack(pos)
+
+	rec, _, ok, err := spool.Next()
+	assert.NoError(t, err)
+	if assert.True(t, ok, "Un enregistrement doit rester disponible") {
+		assert.Equal(t, `{"id":"c"}`, string(rec.Entry), "Le premier enregistrement conservé ne doit pas être sauté")
+	}
+}
+
+// TestLoggerSinkCloseStopsDrainer vérifie que Close attend l'arrêt du drainer sans subir le délai du logger
+func TestLoggerSinkCloseStopsDrainer(t *testing.T) {
+	release := make(chan struct{})
+	loggerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer loggerServer.Close()
+	defer close(release)
+
+	sink := newLoggerSink(Config{
+		LoggerEndpoint:     loggerServer.URL,
+		RetryDelay:         time.Millisecond,
+		SpoolDir:           t.TempDir(),
+		SpoolSegmentBytes:  1024,
+		SpoolFsync:         SpoolFsyncInterval,
+		SpoolDrainInterval: 10 * time.Millisecond,
+	})
+	assert.NoError(t, sink.spool.Append(SpoolRecord{Action: "create", Entry: json.RawMessage(`{"id":"1"}`)}))
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- sink.Close() }()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close doit interrompre l'envoi en cours du drainer")
+	}
+
+	// L'envoi interrompu n'a pas été acquitté: l'enregistrement reste dans le spool
+	spool, err := OpenSpool(sink.config.SpoolDir, 1024, 0, SpoolFsyncNever)
+	assert.NoError(t, err)
+	defer spool.Close()
+	assert.True(t, spool.Pending(), "L'enregistrement non livré doit être conservé")
+}
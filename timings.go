@@ -0,0 +1,197 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// Timings - Décomposition de la durée d'un flux, en microsecondes
+//
+// Connect et TLS ne sont renseignés que pour le flux ayant ouvert la
+// connexion vers le serveur: les flux suivants réutilisent la connexion.
+type Timings struct {
+	Request  int64 `json:"request_us,omitempty"`  // réception de la requête du client
+	Connect  int64 `json:"connect_us,omitempty"`  // résolution DNS et connexion TCP
+	TLS      int64 `json:"tls_us,omitempty"`      // poignée de main TLS avec le serveur
+	TTFB     int64 `json:"ttfb_us,omitempty"`     // attente du premier octet de réponse
+	Transfer int64 `json:"transfer_us,omitempty"` // réception du corps de la réponse
+}
+
+// timingTracker - Horodatages des hooks par connexion client et par flux
+type timingTracker struct {
+	mu    sync.Mutex
+	conns map[string]*connTiming
+	flows map[string]*flowTiming
+}
+
+// connTiming - Étapes de l'ouverture d'une connexion vers le serveur
+type connTiming struct {
+	dialStart time.Time
+	connected time.Time
+	tlsDone   time.Time
+	claimed   bool // déjà attribuée à un flux
+}
+
+// flowTiming - Étapes d'un flux
+type flowTiming struct {
+	headersAt         time.Time
+	requestAt         time.Time
+	responseHeadersAt time.Time
+	conn              *connTiming
+}
+
+func newTimingTracker() *timingTracker {
+	return &timingTracker{
+		conns: make(map[string]*connTiming),
+		flows: make(map[string]*flowTiming),
+	}
+}
+
+// conn - Retourner (en la créant) la mesure de la connexion d'un flux, verrou tenu
+func (t *timingTracker) conn(ctx *proxy.ConnContext) *connTiming {
+	if ctx == nil || ctx.ClientConn == nil {
+		return nil
+	}
+	id := ctx.ClientConn.Id.String()
+	conn, ok := t.conns[id]
+	if !ok {
+		conn = &connTiming{}
+		t.conns[id] = conn
+	}
+	return conn
+}
+
+// flow - Retourner (en la créant) la mesure d'un flux, verrou tenu
+func (t *timingTracker) flow(f *proxy.Flow) *flowTiming {
+	id := f.Id.String()
+	timing, ok := t.flows[id]
+	if !ok {
+		timing = &flowTiming{}
+		t.flows[id] = timing
+	}
+	return timing
+}
+
+// markDialStart - Noter le dernier instant connu avant l'ouverture d'une connexion serveur
+func (t *timingTracker) markDialStart(ctx *proxy.ConnContext, now time.Time) {
+	if ctx == nil || ctx.ServerConn != nil {
+		return
+	}
+	if conn := t.conn(ctx); conn != nil {
+		conn.dialStart = now
+	}
+}
+
+// RequestHeaders - En-têtes de la requête reçus du client
+func (t *timingTracker) RequestHeaders(f *proxy.Flow) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.flow(f).headersAt = now
+	t.markDialStart(f.ConnContext, now)
+}
+
+// Request - Requête complète reçue du client; le flux s'approprie la connexion si elle est neuve
+func (t *timingTracker) Request(f *proxy.Flow) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	timing := t.flow(f)
+	timing.requestAt = now
+	t.markDialStart(f.ConnContext, now)
+	if conn := t.conn(f.ConnContext); conn != nil && !conn.claimed {
+		conn.claimed = true
+		timing.conn = conn
+	}
+}
+
+// ServerConnected - Connexion TCP établie avec le serveur
+func (t *timingTracker) ServerConnected(ctx *proxy.ConnContext) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if conn := t.conn(ctx); conn != nil {
+		conn.connected = time.Now()
+	}
+}
+
+// TLSEstablished - Poignée de main TLS terminée avec le serveur
+func (t *timingTracker) TLSEstablished(ctx *proxy.ConnContext) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if conn := t.conn(ctx); conn != nil {
+		conn.tlsDone = time.Now()
+	}
+}
+
+// ResponseHeaders - En-têtes de la réponse reçus du serveur
+func (t *timingTracker) ResponseHeaders(f *proxy.Flow) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.flow(f).responseHeadersAt = time.Now()
+}
+
+// Finish - Calculer les durées d'un flux terminé et oublier ses horodatages
+func (t *timingTracker) Finish(f *proxy.Flow) *Timings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := f.Id.String()
+	timing, ok := t.flows[id]
+	if !ok {
+		return nil
+	}
+	delete(t.flows, id)
+
+	now := time.Now()
+	timings := &Timings{
+		Request: microsBetween(timing.headersAt, timing.requestAt),
+	}
+
+	// Le serveur est prêt à recevoir la requête après la connexion et la poignée de main TLS
+	ready := timing.requestAt
+	if conn := timing.conn; conn != nil {
+		timings.Connect = microsBetween(conn.dialStart, conn.connected)
+		timings.TLS = microsBetween(conn.connected, conn.tlsDone)
+		if conn.connected.After(ready) {
+			ready = conn.connected
+		}
+		if conn.tlsDone.After(ready) {
+			ready = conn.tlsDone
+		}
+	}
+
+	timings.TTFB = microsBetween(ready, timing.responseHeadersAt)
+	timings.Transfer = microsBetween(timing.responseHeadersAt, now)
+	return timings
+}
+
+// Forget - Oublier les horodatages d'un flux
+func (t *timingTracker) Forget(f *proxy.Flow) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.flows, f.Id.String())
+}
+
+// ClientDisconnected - Oublier la connexion d'un client
+func (t *timingTracker) ClientDisconnected(client *proxy.ClientConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, client.Id.String())
+}
+
+// microsBetween - Durée en microsecondes entre deux instants connus (0 sinon)
+func microsBetween(start, end time.Time) int64 {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start).Microseconds()
+}
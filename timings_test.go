@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTimingsBreakdown vérifie la décomposition connexion / TLS / attente / transfert
+func TestTimingsBreakdown(t *testing.T) {
+	handler, sink := newTestHandler(Config{})
+
+	ctx := &proxy.ConnContext{ClientConn: &proxy.ClientConn{}}
+	f := newTestFlow("GET", "https://example.com/api/resource", nil)
+	f.ConnContext = ctx
+
+	handler.Requestheaders(f)
+	time.Sleep(time.Millisecond)
+	handler.Request(f)
+	time.Sleep(time.Millisecond)
+	handler.ServerConnected(ctx)
+	ctx.ServerConn = &proxy.ServerConn{}
+	time.Sleep(time.Millisecond)
+	handler.TlsEstablishedServer(ctx)
+	time.Sleep(time.Millisecond)
+	f.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header), Body: []byte("ok")}
+	handler.Responseheaders(f)
+	time.Sleep(time.Millisecond)
+	handler.Response(f)
+
+	// Un second flux sur la même connexion ne doit pas se voir attribuer la connexion
+	second := newTestFlow("GET", "https://example.com/api/other", nil)
+	second.ConnContext = ctx
+	handler.Requestheaders(second)
+	handler.Request(second)
+	second.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header)}
+	handler.Responseheaders(second)
+	handler.Response(second)
+	handler.Close()
+
+	// Les workers livrent les entrées dans un ordre quelconque
+	updates := sink.entries(t, "update")
+	assert.Len(t, updates, 2)
+	if strings.HasSuffix(updates[0].HTTPUrl, "/other") {
+		updates[0], updates[1] = updates[1], updates[0]
+	}
+
+	timings := updates[0].Timings
+	if assert.NotNil(t, timings, "Les durées doivent être journalisées") {
+		assert.GreaterOrEqual(t, timings.Request, int64(1000), "Durée de réception de la requête en microsecondes")
+		assert.GreaterOrEqual(t, timings.Connect, int64(1000))
+		assert.GreaterOrEqual(t, timings.TLS, int64(1000))
+		assert.GreaterOrEqual(t, timings.TTFB, int64(1000))
+		assert.GreaterOrEqual(t, timings.Transfer, int64(1000))
+	}
+	if assert.NotNil(t, updates[1].Timings) {
+		assert.Zero(t, updates[1].Timings.Connect, "La connexion réutilisée n'a pas de durée d'ouverture")
+		assert.Zero(t, updates[1].Timings.TLS)
+	}
+}
+
+// TestTimingsForgottenOnDisconnect vérifie que les horodatages ne s'accumulent pas
+func TestTimingsForgottenOnDisconnect(t *testing.T) {
+	handler, _ := newTestHandler(Config{ExcludedRoutes: []string{"/health"}})
+	defer handler.Close()
+
+	ctx := &proxy.ConnContext{ClientConn: &proxy.ClientConn{}}
+	f := newTestFlow("GET", "http://example.com/health", nil)
+	f.ConnContext = ctx
+
+	handler.Requestheaders(f)
+	handler.Request(f)
+	handler.Done(f)
+	handler.ClientDisconnected(ctx.ClientConn)
+
+	assert.Empty(t, handler.timings.flows, "Les flux terminés doivent être oubliés")
+	assert.Empty(t, handler.timings.conns, "Les connexions fermées doivent être oubliées")
+}
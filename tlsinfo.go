@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// TLSInfo - Paramètres TLS de la connexion vers le serveur amont
+type TLSInfo struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	SNI         string `json:"sni,omitempty"`
+	ALPN        string `json:"alpn,omitempty"`
+
+	// Certificat présenté par le serveur
+	CertSubject     string     `json:"cert_subject,omitempty"`
+	CertIssuer      string     `json:"cert_issuer,omitempty"`
+	CertNotAfter    *time.Time `json:"cert_not_after,omitempty"`   // nil sans certificat
+	CertFingerprint string     `json:"cert_fingerprint,omitempty"` // SHA-256 du certificat DER
+	CertChain       []string   `json:"cert_chain,omitempty"`       // sujets des certificats intermédiaires
+	CertExpiresIn   int        `json:"cert_expires_in_days"`
+	CertExpiring    bool       `json:"cert_expiring,omitempty"` // expiré ou expirant sous CertExpiryWarnDays jours
+}
+
+// flowTLSInfo - Paramètres TLS du serveur d'un flux (nil hors HTTPS)
+func (h *MITMHandler) flowTLSInfo(f *proxy.Flow) *TLSInfo {
+	if f.ConnContext == nil || f.ConnContext.ServerConn == nil {
+		return nil
+	}
+	return newTLSInfo(f.ConnContext.ServerConn.TlsState(), h.config.CertExpiryWarnDays, time.Now())
+}
+
+// newTLSInfo - Extraire les paramètres journalisés d'un état de connexion TLS
+func newTLSInfo(state *tls.ConnectionState, warnDays int, now time.Time) *TLSInfo {
+	if state == nil {
+		return nil
+	}
+
+	info := &TLSInfo{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		SNI:         state.ServerName,
+		ALPN:        state.NegotiatedProtocol,
+	}
+	if len(state.PeerCertificates) == 0 {
+		return info
+	}
+
+	leaf := state.PeerCertificates[0]
+	fingerprint := sha256.Sum256(leaf.Raw)
+	info.CertSubject = leaf.Subject.String()
+	info.CertIssuer = leaf.Issuer.String()
+	notAfter := leaf.NotAfter
+	info.CertNotAfter = &notAfter
+	info.CertFingerprint = hex.EncodeToString(fingerprint[:])
+	for _, cert := range state.PeerCertificates[1:] {
+		info.CertChain = append(info.CertChain, cert.Subject.String())
+	}
+
+	info.CertExpiresIn = int(leaf.NotAfter.Sub(now).Hours() / 24)
+	info.CertExpiring = leaf.NotAfter.Before(now.AddDate(0, 0, warnDays))
+	return info
+}
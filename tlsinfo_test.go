@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCertificate - Générer un certificat auto-signé expirant à la date donnée
+func newTestCertificate(t *testing.T, commonName string, notAfter time.Time) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"A2micile"}},
+		NotBefore:    notAfter.AddDate(-1, 0, 0),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert
+}
+
+// TestNewTLSInfo vérifie l'extraction des paramètres TLS et du certificat serveur
+func TestNewTLSInfo(t *testing.T) {
+	now := time.Now()
+	leaf := newTestCertificate(t, "api.example.com", now.AddDate(0, 0, 10).Add(time.Hour))
+	intermediate := newTestCertificate(t, "Intermediate CA", now.AddDate(5, 0, 0))
+
+	state := &tls.ConnectionState{
+		Version:            tls.VersionTLS13,
+		CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+		ServerName:         "api.example.com",
+		NegotiatedProtocol: "http/1.1",
+		PeerCertificates:   []*x509.Certificate{leaf, intermediate},
+	}
+
+	info := newTLSInfo(state, 30, now)
+	if !assert.NotNil(t, info) {
+		return
+	}
+	assert.Equal(t, "TLS 1.3", info.Version)
+	assert.Equal(t, "TLS_AES_128_GCM_SHA256", info.CipherSuite)
+	assert.Equal(t, "api.example.com", info.SNI)
+	assert.Equal(t, "http/1.1", info.ALPN)
+	assert.Equal(t, "CN=api.example.com,O=A2micile", info.CertSubject)
+	assert.Equal(t, info.CertSubject, info.CertIssuer, "Certificat auto-signé")
+	fingerprint := sha256.Sum256(leaf.Raw)
+	assert.Equal(t, hex.EncodeToString(fingerprint[:]), info.CertFingerprint)
+	assert.Equal(t, []string{"CN=Intermediate CA,O=A2micile"}, info.CertChain)
+	if assert.NotNil(t, info.CertNotAfter) {
+		assert.True(t, leaf.NotAfter.Equal(*info.CertNotAfter))
+	}
+	assert.Equal(t, 10, info.CertExpiresIn)
+	assert.True(t, info.CertExpiring, "Un certificat expirant sous 30 jours doit être signalé")
+
+	assert.False(t, newTLSInfo(state, 5, now).CertExpiring, "Le seuil d'alerte doit être configurable")
+	assert.Nil(t, newTLSInfo(nil, 30, now), "Pas de bloc TLS hors HTTPS")
+}
+
+// TestNewTLSInfoExpiredCertificate vérifie qu'un certificat expiré est signalé
+func TestNewTLSInfoExpiredCertificate(t *testing.T) {
+	now := time.Now()
+	expired := newTestCertificate(t, "old.example.com", now.AddDate(0, 0, -3).Add(-time.Hour))
+
+	info := newTLSInfo(&tls.ConnectionState{Version: tls.VersionTLS12, PeerCertificates: []*x509.Certificate{expired}}, 30, now)
+	assert.True(t, info.CertExpiring)
+	assert.Equal(t, -3, info.CertExpiresIn)
+}
+
+// TestNewTLSInfoWithoutCertificate vérifie que la date d'expiration est omise sans certificat
+func TestNewTLSInfoWithoutCertificate(t *testing.T) {
+	info := newTLSInfo(&tls.ConnectionState{Version: tls.VersionTLS13}, 30, time.Now())
+	data, err := json.Marshal(info)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "cert_not_after", "Pas de date nulle 0001-01-01 sans certificat")
+
+	leaf := newTestCertificate(t, "api.example.com", time.Now().AddDate(1, 0, 0))
+	data, err = json.Marshal(newTLSInfo(&tls.ConnectionState{Version: tls.VersionTLS13, PeerCertificates: []*x509.Certificate{leaf}}, 30, time.Now()))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "cert_not_after")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+)
+
+// En-tête W3C Trace Context (https://www.w3.org/TR/trace-context/)
+const traceparentHeader = "traceparent"
+
+// traceContext - Contexte de trace d'un flux
+//
+// SpanID identifie le passage par le proxy: c'est le parent annoncé au
+// serveur amont. ParentSpanID est le parent reçu du client, s'il y en a un.
+type traceContext struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Flags        string
+}
+
+// parseTraceparent - Lire un en-tête traceparent ("00-<trace-id>-<parent-id>-<flags>")
+//
+// Les versions supérieures à 00 sont acceptées si elles commencent par les
+// mêmes champs; la version ff et les identifiants nuls sont invalides.
+func parseTraceparent(value string) (traceContext, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 55 || (len(value) > 55 && (value[:2] == "00" || value[55] != '-')) {
+		return traceContext{}, false
+	}
+	version, traceID, parentID, flags := value[:2], value[3:35], value[36:52], value[53:55]
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' || version == "ff" {
+		return traceContext{}, false
+	}
+	for _, field := range []string{version, traceID, parentID, flags} {
+		if !isLowerHex(field) {
+			return traceContext{}, false
+		}
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return traceContext{}, false
+	}
+	return traceContext{TraceID: traceID, ParentSpanID: parentID, Flags: flags}, true
+}
+
+// continueTrace - Poursuivre la trace reçue du client, ou en démarrer une nouvelle
+func continueTrace(incoming string) traceContext {
+	trace, ok := parseTraceparent(incoming)
+	if !ok {
+		trace = traceContext{TraceID: randomHex(16), Flags: "01"}
+	}
+	trace.SpanID = randomHex(8)
+	return trace
+}
+
+// traceparent - Valeur de l'en-tête traceparent transmis au serveur amont
+func (t traceContext) traceparent() string {
+	return "00-" + t.TraceID + "-" + t.SpanID + "-" + t.Flags
+}
+
+// isLowerHex - Indiquer si une chaîne ne contient que des chiffres hexadécimaux minuscules
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex - Identifiant aléatoire de n octets en hexadécimal
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// propagateContext - Transmettre au serveur amont l'ID de corrélation et le contexte de trace
+//
+// Retourne le contexte à enregistrer sur l'entrée: celui transmis au serveur
+// amont, ou celui reçu du client quand la propagation est désactivée.
+func (h *MITMHandler) propagateContext(req *proxy.Request, correlationID string) traceContext {
+	if h.config.PropagateCorrelation {
+		name := h.identity.correlationHeader()
+		if req.Header.Get(name) == "" {
+			req.Header.Set(name, correlationID)
+		}
+	}
+
+	incoming := req.Header.Get(traceparentHeader)
+	if !h.config.PropagateTraceContext {
+		trace, _ := parseTraceparent(incoming)
+		return trace
+	}
+	trace := continueTrace(incoming)
+	req.Header.Set(traceparentHeader, trace.traceparent())
+	return trace
+}
+
+// echoContext - Renvoyer au client l'ID de corrélation et le traceparent transmis au serveur amont
+func (h *MITMHandler) echoContext(f *proxy.Flow) {
+	if f.Response == nil || f.Response.Header == nil {
+		return
+	}
+	if h.config.PropagateCorrelation {
+		name := h.identity.correlationHeader()
+		if value := f.Request.Header.Get(name); value != "" && f.Response.Header.Get(name) == "" {
+			f.Response.Header.Set(name, value)
+		}
+	}
+	if h.config.PropagateTraceContext {
+		if value := f.Request.Header.Get(traceparentHeader); value != "" {
+			f.Response.Header.Set(traceparentHeader, value)
+		}
+	}
+}
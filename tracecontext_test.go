@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// TestParseTraceparent vérifie la lecture et la validation de l'en-tête traceparent
+func TestParseTraceparent(t *testing.T) {
+	trace, ok := parseTraceparent(testTraceparent)
+	assert.True(t, ok)
+	assert.Equal(t, traceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", ParentSpanID: "00f067aa0ba902b7", Flags: "01"}, trace)
+
+	_, ok = parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extension")
+	assert.True(t, ok, "Une version supérieure avec des champs supplémentaires doit être acceptée")
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extension",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+	} {
+		_, ok := parseTraceparent(value)
+		assert.False(t, ok, "L'en-tête %q doit être refusé", value)
+	}
+}
+
+// TestPropagateContextStartsAndContinuesTraces vérifie l'injection et le renvoi des identifiants
+func TestPropagateContextStartsAndContinuesTraces(t *testing.T) {
+	handler, sink := newTestHandler(Config{PropagateCorrelation: true, PropagateTraceContext: true})
+
+	fresh := newTestFlow("GET", "http://example.com/nouvelle", nil)
+	handler.Request(fresh)
+	fresh.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header)}
+	handler.Responseheaders(fresh)
+
+	continued := newTestFlow("GET", "http://example.com/suite", nil)
+	continued.Request.Header.Set("traceparent", testTraceparent)
+	continued.Request.Header.Set("correlation-id", "corr-client")
+	handler.Request(continued)
+	handler.Close()
+
+	for _, entry := range sink.entries(t, "create") {
+		switch entry.HTTPUrl {
+		case "http://example.com/nouvelle":
+			assert.Equal(t, entry.CorrelationID, fresh.Request.Header.Get("correlation-id"),
+				"L'ID de corrélation généré doit être transmis au serveur amont")
+			assert.Equal(t, "00-"+entry.TraceID+"-"+entry.SpanID+"-01", fresh.Request.Header.Get("traceparent"))
+			assert.Len(t, entry.TraceID, 32)
+			assert.Empty(t, entry.ParentSpanID)
+			assert.Equal(t, entry.CorrelationID, fresh.Response.Header.Get("correlation-id"), "L'ID doit être renvoyé au client")
+			assert.Equal(t, fresh.Request.Header.Get("traceparent"), fresh.Response.Header.Get("traceparent"))
+		case "http://example.com/suite":
+			assert.Equal(t, "corr-client", continued.Request.Header.Get("correlation-id"), "L'ID du client ne doit pas être remplacé")
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", entry.TraceID, "La trace du client doit être poursuivie")
+			assert.Equal(t, "00f067aa0ba902b7", entry.ParentSpanID)
+			assert.NotEqual(t, "00f067aa0ba902b7", entry.SpanID)
+			assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+entry.SpanID+"-01", continued.Request.Header.Get("traceparent"))
+		default:
+			t.Errorf("Entrée inattendue: %s", entry.HTTPUrl)
+		}
+	}
+}
+
+// TestPropagateContextCustomHeaderAndDisabled vérifie l'en-tête de la chaîne de corrélation et la propagation désactivée
+func TestPropagateContextCustomHeaderAndDisabled(t *testing.T) {
+	handler, _ := newTestHandler(Config{
+		PropagateCorrelation: true,
+		IdentityCorrelation:  []string{"query:rid", "header:X-Request-Id"},
+	})
+	defer handler.Close()
+
+	f := newTestFlow("GET", "http://example.com/?rid=abc", nil)
+	trace := handler.propagateContext(f.Request, "abc")
+	assert.Equal(t, "abc", f.Request.Header.Get("X-Request-Id"), "L'en-tête de la chaîne de corrélation doit être utilisé")
+	assert.Empty(t, f.Request.Header.Get("traceparent"), "Le contexte de trace ne doit pas être injecté s'il est désactivé")
+	assert.Equal(t, traceContext{}, trace)
+
+	disabled, _ := newTestHandler(Config{})
+	defer disabled.Close()
+	f = newTestFlow("GET", "http://example.com/", nil)
+	f.Request.Header.Set("traceparent", testTraceparent)
+	trace = disabled.propagateContext(f.Request, "corr")
+	assert.Empty(t, f.Request.Header.Get("correlation-id"))
+	assert.Equal(t, testTraceparent, f.Request.Header.Get("traceparent"), "La requête ne doit pas être modifiée")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace.TraceID, "La trace reçue doit être enregistrée")
+}
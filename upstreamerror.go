@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Catégories d'échec de la connexion vers le serveur amont
+const (
+	ErrorKindDNS     = "dns"
+	ErrorKindConnect = "connect"
+	ErrorKindTLS     = "tls"
+	ErrorKindTimeout = "timeout"
+	ErrorKindReset   = "reset"
+	ErrorKindUnknown = "unknown"
+)
+
+// upstreamErrorTTL - Durée de conservation d'une erreur amont non réclamée
+const upstreamErrorTTL = time.Minute
+
+// upstreamErrorHook - Capture les erreurs amont journalisées par go-mitmproxy
+//
+// La bibliothèque n'expose pas l'erreur aux addons: elle la journalise via
+// logrus puis répond 502. Le hook mémorise le message par méthode et URL
+// pour que le flux concerné puisse le récupérer à sa clôture.
+type upstreamErrorHook struct {
+	mu     sync.Mutex
+	errors map[string]upstreamError
+}
+
+type upstreamError struct {
+	message string
+	at      time.Time
+}
+
+var (
+	upstreamErrors         = &upstreamErrorHook{errors: make(map[string]upstreamError)}
+	upstreamErrorsRegister sync.Once
+)
+
+// registerUpstreamErrorHook - Brancher le hook sur le logger logrus global (une seule fois)
+//
+// Le niveau du logger n'est pas modifié. go-mitmproxy journalise les échecs
+// de connexion, DNS et TLS au niveau Error, mais les coupures courantes en
+// cours d'échange (réinitialisation, timeout de lecture) au niveau Debug:
+// sans journalisation Debug, ces flux sont catégorisés d'après l'état de la
+// connexion (voir MITMHandler.Error).
+func registerUpstreamErrorHook() {
+	upstreamErrorsRegister.Do(func() {
+		logrus.AddHook(upstreamErrors)
+	})
+}
+
+// Levels - Niveaux interceptés par le hook
+func (u *upstreamErrorHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.ErrorLevel, logrus.WarnLevel, logrus.DebugLevel}
+}
+
+// Fire - Mémoriser l'erreur d'une requête relayée ou d'un tunnel CONNECT
+//
+// Les erreurs d'un tunnel ne portent que l'adresse ("host"): celle du serveur
+// visé si la connexion amont échoue, celle du client si la poignée de main
+// TLS échoue. Elles sont mémorisées sous la méthode CONNECT; les messages
+// Debug qui portent aussi l'adresse ("begin intercept"...) sont ignorés.
+func (u *upstreamErrorHook) Fire(entry *logrus.Entry) error {
+	method, _ := entry.Data["method"].(string)
+	target, ok := entry.Data["url"]
+	if !ok || method == "" {
+		if host, _ := entry.Data["host"].(string); host != "" && entry.Level <= logrus.WarnLevel {
+			u.Record(http.MethodConnect, host, entry.Message)
+		}
+		return nil
+	}
+	var rawURL string
+	switch v := target.(type) {
+	case interface{ String() string }:
+		rawURL = v.String()
+	case string:
+		rawURL = v
+	default:
+		return nil
+	}
+	u.Record(method, rawURL, entry.Message)
+	return nil
+}
+
+// Record - Mémoriser le message d'erreur d'une requête
+func (u *upstreamErrorHook) Record(method, rawURL, message string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	for key, err := range u.errors {
+		if now.Sub(err.at) > upstreamErrorTTL {
+			delete(u.errors, key)
+		}
+	}
+	u.errors[method+" "+rawURL] = upstreamError{message: message, at: now}
+}
+
+// Take - Récupérer et oublier le message d'erreur d'une requête
+func (u *upstreamErrorHook) Take(method, rawURL string) (string, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	key := method + " " + rawURL
+	err, ok := u.errors[key]
+	if !ok {
+		return "", false
+	}
+	delete(u.errors, key)
+	return err.message, true
+}
+
+// classifyUpstreamError - Déterminer la catégorie d'un message d'erreur amont
+func classifyUpstreamError(message string) string {
+	msg := strings.ToLower(message)
+	switch {
+	case containsAny(msg, "no such host", "lookup ", "server misbehaving"):
+		return ErrorKindDNS
+	case containsAny(msg, "timeout", "deadline exceeded", "timed out"):
+		return ErrorKindTimeout
+	case containsAny(msg, "tls:", "x509:", "certificate", "handshake"):
+		return ErrorKindTLS
+	case containsAny(msg, "connection reset", "broken pipe", "eof", "closed pipe", "closed network connection"):
+		return ErrorKindReset
+	case containsAny(msg, "connection refused", "no route to host", "network is unreachable", "dial "):
+		return ErrorKindConnect
+	default:
+		return ErrorKindUnknown
+	}
+}
+
+// upstreamErrorStatus - Code HTTP journalisé pour une catégorie d'erreur (408 en cas de timeout, 500 sinon)
+func upstreamErrorStatus(kind string) int {
+	if kind == ErrorKindTimeout {
+		return http.StatusRequestTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
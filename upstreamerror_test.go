@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lqqyt2423/go-mitmproxy/proxy"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClassifyUpstreamError vérifie la catégorisation des messages d'erreur amont
+func TestClassifyUpstreamError(t *testing.T) {
+	cases := map[string]string{
+		`dial tcp: lookup api.invalid: no such host`:                   ErrorKindDNS,
+		`dial tcp 10.0.0.1:443: connect: connection refused`:           ErrorKindConnect,
+		`tls: failed to verify certificate: x509: certificate expired`: ErrorKindTLS,
+		`net/http: TLS handshake timeout`:                              ErrorKindTimeout,
+		`context deadline exceeded`:                                    ErrorKindTimeout,
+		`read tcp 10.0.0.2:5000: connection reset by peer`:             ErrorKindReset,
+		`unexpected EOF`:            ErrorKindReset,
+		`quelque chose d'inattendu`: ErrorKindUnknown,
+	}
+	for message, kind := range cases {
+		assert.Equal(t, kind, classifyUpstreamError(message), "Catégorie inattendue pour %q", message)
+	}
+}
+
+// TestUpstreamErrorHookCapturesLibraryLogs vérifie que le hook mémorise les erreurs par méthode et URL
+func TestUpstreamErrorHookCapturesLibraryLogs(t *testing.T) {
+	hook := &upstreamErrorHook{errors: make(map[string]upstreamError)}
+	u, _ := url.Parse("https://api.example.com/orders")
+
+	logger := logrus.New()
+	logger.AddHook(hook)
+	logger.WithFields(logrus.Fields{"in": "Proxy.attacker.attack", "url": u, "method": "POST"}).Error("dial tcp: connection refused")
+
+	message, ok := hook.Take("POST", u.String())
+	assert.True(t, ok, "L'erreur doit être mémorisée")
+	assert.Equal(t, "dial tcp: connection refused", message)
+
+	_, ok = hook.Take("POST", u.String())
+	assert.False(t, ok, "L'erreur ne doit être récupérée qu'une fois")
+}
+
+// TestErrorFinalisesPendingEntry vérifie qu'un échec amont produit une mise à jour critique
+func TestErrorFinalisesPendingEntry(t *testing.T) {
+	handler, sink := newTestHandler(Config{MaskQueryParams: []string{"token"}})
+
+	f := newTestFlow("GET", "https://api.invalid/orders?token=secret", nil)
+	handler.Request(f)
+	upstreamErrors.Record("GET", f.Request.URL.String(),
+		`Get "https://api.invalid/orders?token=secret": dial tcp: lookup api.invalid: no such host`)
+	handler.Done(f)
+	handler.Close()
+
+	updates := sink.entries(t, "update")
+	assert.Len(t, updates, 1)
+	assert.Equal(t, ErrorKindDNS, updates[0].ErrorKind)
+	assert.Equal(t, http.StatusInternalServerError, updates[0].HTTPReturnCode)
+	assert.Equal(t, "critical", updates[0].LogType)
+	assert.NotContains(t, updates[0].ErrorMessage, "secret", "Le message d'erreur ne doit pas exposer l'URL brute")
+	assert.Contains(t, updates[0].ErrorMessage, "no such host")
+}
+
+// TestErrorTimeoutUses408 vérifie le code 408 pour les timeouts amont
+func TestErrorTimeoutUses408(t *testing.T) {
+	handler, sink := newTestHandler(Config{})
+
+	f := newTestFlow("GET", "http://slow.example.com/report", nil)
+	handler.Request(f)
+	upstreamErrors.Record("GET", f.Request.URL.String(), "context deadline exceeded")
+	handler.Error(f)
+	handler.Error(f)
+	handler.Close()
+
+	updates := sink.entries(t, "update")
+	assert.Len(t, updates, 1, "Un flux ne doit être finalisé qu'une fois")
+	assert.Equal(t, ErrorKindTimeout, updates[0].ErrorKind)
+	assert.Equal(t, http.StatusRequestTimeout, updates[0].HTTPReturnCode)
+}
+
+// TestDoneFinalisesStreamedResponse vérifie qu'une réponse diffusée en streaming est journalisée à la fin du flux
+func TestDoneFinalisesStreamedResponse(t *testing.T) {
+	handler, sink := newTestHandler(Config{})
+
+	f := newTestFlow("GET", "http://example.com/download", nil)
+	handler.Request(f)
+	f.Response = &proxy.Response{StatusCode: 200, Header: make(http.Header)}
+	handler.Done(f)
+	handler.Close()
+
+	updates := sink.entries(t, "update")
+	assert.Len(t, updates, 1)
+	assert.Equal(t, 200, updates[0].HTTPReturnCode)
+	assert.Empty(t, updates[0].ErrorKind)
+}
+
+// deadlineAddon - Imposer un délai de lecture sur la connexion amont pour provoquer un vrai timeout
+type deadlineAddon struct {
+	proxy.BaseAddon
+}
+
+func (*deadlineAddon) ServerConnected(ctx *proxy.ConnContext) {
+	ctx.ServerConn.Conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+}
+
+// TestUpstreamTimeoutThroughProxy vérifie qu'un timeout journalisé en Debug par go-mitmproxy donne un 408
+//
+// Le message n'est disponible que si la journalisation Debug est activée par l'exploitant.
+func TestUpstreamTimeoutThroughProxy(t *testing.T) {
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.DebugLevel)
+	defer logrus.SetLevel(level)
+
+	// Serveur amont qui accepte la connexion sans jamais répondre
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	handler, sink := newTestHandler(Config{})
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel(), "Le hook ne doit pas modifier le niveau du logger")
+
+	addr := startTestProxy(t, nil, &deadlineAddon{}, handler)
+	proxyURL, _ := url.Parse("http://" + addr)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+
+	resp, err := client.Get(fmt.Sprintf("http://%s/slow", upstream.Addr()))
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	}
+
+	assert.Eventually(t, func() bool { return len(sink.entries(t, "update")) > 0 }, 2*time.Second, 10*time.Millisecond)
+	handler.Close()
+
+	updates := sink.entries(t, "update")
+	if assert.Len(t, updates, 1) {
+		assert.Equal(t, ErrorKindTimeout, updates[0].ErrorKind)
+		assert.Equal(t, http.StatusRequestTimeout, updates[0].HTTPReturnCode)
+		assert.Contains(t, updates[0].ErrorMessage, "i/o timeout")
+	}
+}
+
+// TestUpstreamHTTPSFailuresThroughProxy vérifie la journalisation des échecs amont survenus pendant le CONNECT
+func TestUpstreamHTTPSFailuresThroughProxy(t *testing.T) {
+	level := logrus.GetLevel()
+	handler, sink := newTestHandler(Config{})
+	assert.Equal(t, level, logrus.GetLevel(), "Le hook ne doit pas modifier le niveau du logger")
+
+	// Port fermé: la connexion amont échoue avant l'ouverture du tunnel
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	refusedAddr := closed.Addr().String()
+	closed.Close()
+
+	// Serveur HTTP en clair: la poignée de main TLS amont échoue dans le tunnel
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	_, port, _ := net.SplitHostPort(plain.Listener.Addr().String())
+	plainAddr := "localhost:" + port
+
+	addr := startTestProxy(t, nil, handler)
+	proxyURL, _ := url.Parse("http://" + addr)
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		Timeout: 5 * time.Second,
+	}
+	for _, target := range []string{refusedAddr, plainAddr} {
+		resp, err := client.Get(fmt.Sprintf("https://%s/orders", target))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	assert.Eventually(t, func() bool { return len(sink.entries(t, "update")) == 2 }, 2*time.Second, 10*time.Millisecond)
+	handler.Close()
+
+	creates := sink.entries(t, "create")
+	assert.Len(t, creates, 2, "Chaque tunnel en échec doit être journalisé")
+	kinds := make(map[string]LogModel)
+	for _, update := range sink.entries(t, "update") {
+		kinds[update.HTTPUrl] = update
+	}
+	if refused, ok := kinds["https://"+refusedAddr]; assert.True(t, ok) {
+		assert.Equal(t, ErrorKindConnect, refused.ErrorKind)
+		assert.Contains(t, refused.ErrorMessage, "connection refused")
+		assert.Equal(t, "critical", refused.LogType)
+	}
+	if handshake, ok := kinds["https://"+plainAddr]; assert.True(t, ok) {
+		assert.Equal(t, ErrorKindTLS, handshake.ErrorKind)
+		assert.Contains(t, handshake.ErrorMessage, "tls:")
+	}
+}
+
+// startTestProxy - Démarrer go-mitmproxy sur un port libre avec les addons donnés
+//
+// configure (facultatif) est appelé avant le démarrage. Retourne l'adresse du proxy.
+func startTestProxy(t *testing.T, configure func(*proxy.Proxy), addons ...proxy.Addon) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	p, err := proxy.NewProxy(&proxy.Options{Addr: addr, StreamLargeBodies: 1024 * 1024})
+	assert.NoError(t, err)
+	for _, addon := range addons {
+		p.AddAddon(addon)
+	}
+	if configure != nil {
+		configure(p)
+	}
+	go p.Start()
+	t.Cleanup(func() { p.Close() })
+
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond, "Le proxy doit démarrer")
+	return addr
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// Politiques appliquées lorsque la file d'envoi est pleine
+const (
+	OverflowBlock      = "block"       // attendre qu'une place se libère
+	OverflowDropOldest = "drop_oldest" // abandonner l'entrée la plus ancienne
+	OverflowDropNewest = "drop_newest" // abandonner la nouvelle entrée
+	OverflowSpill      = "spill"       // écrire la nouvelle entrée dans le spool disque
+)
+
+// logJob - Entrée de journal sérialisée en attente d'envoi
+type logJob struct {
+	action string
+	data   []byte
+	key    string // flux ou connexion: les entrées de même clé sont livrées dans l'ordre
+}
+
+// logQueue - File bornée consommée par un nombre fixe de workers
+//
+// Chaque worker a sa propre file, de capacité size/workers: les entrées de
+// même clé passent toutes par le même worker, de sorte que la mise à jour
+// d'un flux n'est jamais livrée avant sa création.
+type logQueue struct {
+	shards  []chan logJob
+	next    atomic.Uint64 // répartition des entrées sans clé
+	policy  string
+	deliver func(logJob)
+	spill   func(logJob) bool // retourne false si le débordement sur disque est impossible
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	enqueued      atomic.Int64
+	droppedOldest atomic.Int64
+	droppedNewest atomic.Int64
+	spilled       atomic.Int64
+}
+
+// newLogQueue - Créer la file et démarrer les workers
+func newLogQueue(workers, size int, policy string, deliver func(logJob), spill func(logJob) bool) *logQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	shardSize := (size + workers - 1) / workers
+	q := &logQueue{
+		shards:  make([]chan logJob, workers),
+		policy:  policy,
+		deliver: deliver,
+		spill:   spill,
+	}
+	for i := range q.shards {
+		q.shards[i] = make(chan logJob, shardSize)
+		q.wg.Add(1)
+		go q.work(q.shards[i])
+	}
+	return q
+}
+
+// shard - File du worker chargé d'une entrée
+func (q *logQueue) shard(job logJob) chan logJob {
+	if len(q.shards) == 1 {
+		return q.shards[0]
+	}
+	if job.key == "" {
+		return q.shards[q.next.Add(1)%uint64(len(q.shards))]
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(job.key))
+	return q.shards[hash.Sum32()%uint32(len(q.shards))]
+}
+
+// Push - Ajouter une entrée en appliquant la politique de débordement
+func (q *logQueue) Push(job logJob) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		q.droppedNewest.Add(1)
+		return
+	}
+
+	jobs := q.shard(job)
+	select {
+	case jobs <- job:
+		q.enqueued.Add(1)
+		return
+	default:
+	}
+
+	// La file est pleine
+	switch q.policy {
+	case OverflowBlock:
+		jobs <- job
+		q.enqueued.Add(1)
+	case OverflowDropOldest:
+		for {
+			select {
+			case jobs <- job:
+				q.enqueued.Add(1)
+				return
+			default:
+			}
+			select {
+			case <-jobs:
+				q.droppedOldest.Add(1)
+			default:
+			}
+		}
+	case OverflowSpill:
+		if q.spill != nil && q.spill(job) {
+			q.spilled.Add(1)
+			return
+		}
+		q.droppedNewest.Add(1)
+	default:
+		q.droppedNewest.Add(1)
+	}
+}
+
+// Len - Nombre d'entrées en attente dans la file
+func (q *logQueue) Len() int {
+	n := 0
+	for _, jobs := range q.shards {
+		n += len(jobs)
+	}
+	return n
+}
+
+// Close - Refuser les nouvelles entrées et attendre l'envoi de celles en attente
+func (q *logQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	for _, jobs := range q.shards {
+		close(jobs)
+	}
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+// work - Consommer la file d'un worker jusqu'à sa fermeture
+func (q *logQueue) work(jobs chan logJob) {
+	defer q.wg.Done()
+
+	for job := range jobs {
+		q.deliver(job)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockedQueue - Créer une file dont l'unique worker reste bloqué jusqu'à release
+func blockedQueue(size int, policy string, spill func(logJob) bool) (*logQueue, chan struct{}, *[]string, *sync.Mutex) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	var mu sync.Mutex
+	var delivered []string
+
+	q := newLogQueue(1, size, policy, func(job logJob) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		mu.Lock()
+		delivered = append(delivered, string(job.data))
+		mu.Unlock()
+	}, spill)
+
+	// Occuper le worker avec une première entrée
+	q.Push(logJob{action: "create", data: []byte("busy")})
+	<-started
+	return q, release, &delivered, &mu
+}
+
+// TestLogQueueDropOldest vérifie que la politique drop_oldest conserve les entrées les plus récentes
+func TestLogQueueDropOldest(t *testing.T) {
+	q, release, delivered, mu := blockedQueue(2, OverflowDropOldest, nil)
+
+	for _, data := range []string{"1", "2", "3", "4"} {
+		q.Push(logJob{action: "create", data: []byte(data)})
+	}
+	assert.Equal(t, int64(2), q.droppedOldest.Load(), "Deux entrées anciennes doivent être abandonnées")
+
+	close(release)
+	q.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"busy", "3", "4"}, *delivered, "Les entrées les plus récentes doivent être livrées")
+}
+
+// TestLogQueueDropNewest vérifie que la politique drop_newest refuse les nouvelles entrées
+func TestLogQueueDropNewest(t *testing.T) {
+	q, release, delivered, mu := blockedQueue(1, OverflowDropNewest, nil)
+
+	q.Push(logJob{action: "create", data: []byte("1")})
+	q.Push(logJob{action: "create", data: []byte("2")})
+	assert.Equal(t, int64(1), q.droppedNewest.Load(), "La nouvelle entrée doit être abandonnée")
+
+	close(release)
+	q.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"busy", "1"}, *delivered)
+}
+
+// TestLogQueueSpill vérifie que la politique spill transmet les entrées refusées au spool
+func TestLogQueueSpill(t *testing.T) {
+	var spilled []string
+	q, release, _, _ := blockedQueue(1, OverflowSpill, func(job logJob) bool {
+		spilled = append(spilled, string(job.data))
+		return true
+	})
+
+	q.Push(logJob{action: "create", data: []byte("1")})
+	q.Push(logJob{action: "update", data: []byte("2")})
+
+	close(release)
+	q.Close()
+
+	assert.Equal(t, []string{"2"}, spilled, "L'entrée en débordement doit être écrite dans le spool")
+	assert.Equal(t, int64(1), q.spilled.Load())
+}
+
+// TestLogQueueKeepsFlowOrder vérifie que la mise à jour d'un flux n'est jamais livrée avant sa création
+func TestLogQueueKeepsFlowOrder(t *testing.T) {
+	var mu sync.Mutex
+	created := make(map[string]bool)
+	var outOfOrder []string
+
+	q := newLogQueue(8, 64, OverflowBlock, func(job logJob) {
+		// Ralentir les créations pour laisser les autres workers prendre de l'avance
+		if job.action == "create" {
+			time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if job.action == "create" {
+			created[job.key] = true
+		} else if !created[job.key] {
+			outOfOrder = append(outOfOrder, job.key)
+		}
+	}, nil)
+
+	var producers sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		producers.Add(1)
+		go func(p int) {
+			defer producers.Done()
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("flux-%d-%d", p, i)
+				q.Push(logJob{action: "create", data: []byte(key), key: key})
+				q.Push(logJob{action: "update", data: []byte(key), key: key})
+			}
+		}(p)
+	}
+	producers.Wait()
+	q.Close()
+
+	assert.Len(t, created, 400)
+	assert.Empty(t, outOfOrder, "Des mises à jour ont été livrées avant la création de leur flux")
+}